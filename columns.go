@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/erik/mixport/exports"
+	"github.com/erik/mixport/mixpanel"
+	flag "github.com/ogier/pflag"
+	"gopkg.in/gcfg.v1"
+)
+
+// generatedColumns are put in front of the properties of every event when
+// generating column definitions.
+var generatedColumns = []string{mixpanel.EventIDKey, mixpanel.TimestampKey}
+
+// columnsCommand implements `mixport columns generate`, which writes column
+// definitions for the `[columns]` export based on the events and properties
+// defined in each product's Lexicon.
+func columnsCommand(args []string) {
+	flags := flag.NewFlagSet("columns", flag.ExitOnError)
+
+	flags.Usage = func() {
+		fmt.Println(`Usage: mixport columns generate [OPTIONS]
+
+Generate column definitions from the Lexicon of each product, which requires
+service account credentials (see 'auth' in the example configuration).
+
+Each event gets the columns ` + strings.Join(generatedColumns, ", ") + `,
+followed by its properties in alphabetical order.
+
+Options:
+  -h, --help      Display this message.
+  -c, --config    Path to configuration file, defaulting to "./mixport.conf"
+  --products      Comma separated list of products to generate columns for.
+  -m, --merge     Existing column definitions to merge the generated ones
+                  into. Columns already defined keep their order, new ones
+                  are appended, and nothing is removed.
+  -o, --output    File to write the column definitions to, defaulting to
+                  standard output. May be the same as --merge.`)
+	}
+
+	var (
+		configFile  = flags.StringP("config", "c", "./mixport.conf", "")
+		productList = flags.String("products", "", "")
+		mergeFile   = flags.StringP("merge", "m", "", "")
+		outputFile  = flags.StringP("output", "o", "", "")
+	)
+
+	if len(args) == 0 || args[0] != "generate" {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	flags.Parse(args[1:])
+
+	if err := gcfg.ReadFileInto(&cfg, *configFile); err != nil {
+		log.Fatalf("Failed to load %s: %s", *configFile, err)
+	}
+
+	setupAPI()
+
+	columns := make(map[string]map[string][]string)
+
+	if *mergeFile != "" {
+		fp, err := os.Open(*mergeFile)
+		if err != nil {
+			log.Fatalf("Couldn't open column definitions: %v", err)
+		}
+
+		err = json.NewDecoder(fp).Decode(&columns)
+		fp.Close()
+
+		if err != nil {
+			log.Fatalf("Failed to read column definitions: %v", err)
+		}
+	}
+
+	var names []string
+
+	if *productList == "" {
+		for name := range cfg.Product {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	} else {
+		names = strings.Split(*productList, ",")
+	}
+
+	for _, product := range names {
+		conf, ok := cfg.Product[product]
+		if !ok {
+			log.Fatalf("Don't have credentials specified for %s", product)
+		}
+
+		if conf.ProjectID == "" {
+			log.Fatalf("%s: generating columns requires `projectid`", product)
+		}
+
+		client, err := newClient(exportConfig{Product: product, Conf: *conf})
+		if err != nil {
+			log.Fatalf("%s: %s", product, err)
+		}
+
+		schemas, err := client.EventSchemas(context.Background(), conf.ProjectID)
+		if err != nil {
+			log.Fatalf("%s", err)
+		}
+
+		if columns[product] == nil {
+			columns[product] = make(map[string][]string)
+		}
+
+		for _, schema := range schemas {
+			generated := append(append([]string(nil), generatedColumns...), schema.Properties...)
+			columns[product][schema.Name] = exports.MergeColumns(columns[product][schema.Name], generated)
+		}
+
+		log.Printf("%s: %d events.", product, len(schemas))
+	}
+
+	encoded, err := json.MarshalIndent(columns, "", "  ")
+	if err != nil {
+		log.Fatalf("Failed to encode column definitions: %v", err)
+	}
+
+	var output io.Writer = os.Stdout
+
+	if *outputFile != "" {
+		fp, err := os.Create(*outputFile)
+		if err != nil {
+			log.Fatalf("Couldn't create file: %s", err)
+		}
+
+		defer fp.Close()
+		output = fp
+	}
+
+	if _, err := fmt.Fprintf(output, "%s\n", encoded); err != nil {
+		log.Fatalf("Failed to write column definitions: %v", err)
+	}
+}
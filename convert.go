@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/erik/mixport/mixpanel"
+	flag "github.com/ogier/pflag"
+	"gopkg.in/gcfg.v1"
+)
+
+// convertCommand implements `mixport convert`, which runs local dumps of
+// event data through the configured exports as if they had just been
+// downloaded.
+func convertCommand(args []string) {
+	flags := flag.NewFlagSet("convert", flag.ExitOnError)
+
+	flags.Usage = func() {
+		fmt.Println(`Usage: mixport convert [OPTIONS] FILE...
+
+Transform local dumps of event data and write them to the configured exports,
+exactly like the data of a download. The files may be gzip compressed, and
+contain raw records of Mixpanel's export API as well as JSON written by
+mixport itself.
+
+Options:
+  -h, --help      Display this message.
+  -c, --config    Path to configuration file, defaulting to "./mixport.conf"
+  --product       Product the data belongs to, whose configuration section is
+                  used for the conversion.
+  -d, --date      Date of the data in YYYY/MM/DD, used to name the output
+                  files.
+  -r, --range     Date range of the data in YYYY/MM/DD-YYYY/MM/DD format,
+                  used to name the output files.`)
+	}
+
+	var (
+		configFile  = flags.StringP("config", "c", "./mixport.conf", "")
+		product     = flags.String("product", "", "")
+		dateString  = flags.StringP("date", "d", "", "")
+		rangeString = flags.StringP("range", "r", "", "")
+	)
+
+	flags.Parse(args)
+
+	files := flags.Args()
+
+	if *product == "" || len(files) == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	if *dateString == "" && *rangeString == "" {
+		log.Fatalf("Converting requires either --date or --range")
+	}
+
+	if err := gcfg.ReadFileInto(&cfg, *configFile); err != nil {
+		log.Fatalf("Failed to load %s: %s", *configFile, err)
+	}
+
+	start, end := parseDates(*dateString, *rangeString)
+
+	checkFileConfigs()
+
+	conf, ok := cfg.Product[*product]
+	if !ok {
+		log.Fatalf("Don't have a configuration for %s", *product)
+	}
+
+	export := exportConfig{Product: *product, Conf: *conf}
+
+	client, err := newClient(export)
+	if err != nil {
+		log.Fatalf("%s: %s", *product, err)
+	}
+
+	loc := time.UTC
+	if client.Location != nil {
+		loc = client.Location
+	}
+
+	export.Start = midnight(start, loc)
+	export.End = midnight(end, loc)
+
+	ctx, cancel := cancelOnSignal()
+	defer cancel()
+
+	convert := func(ctx context.Context, eventData chan<- mixpanel.EventData) (int, error) {
+		total := 0
+
+		for _, name := range files {
+			n, err := convertFile(ctx, client, name, eventData)
+			total += n
+
+			if err != nil {
+				log.Printf("%s: %s: failed after %d records.", *product, name, n)
+				return total, err
+			}
+
+			log.Printf("%s: %s: %d records.", *product, name, n)
+		}
+
+		return total, nil
+	}
+
+	closeRejects := quarantine(export, client)
+
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	exportStream(ctx, export, client, "", convert, &wg)
+	wg.Wait()
+
+	closeRejects()
+
+	exitIfFailed()
+}
+
+// convertFile sends the transformed records of the named file on
+// `eventData`, decompressing it first if it's gzip compressed. Returns the
+// number of records sent.
+func convertFile(ctx context.Context, client *mixpanel.Mixpanel, name string, eventData chan<- mixpanel.EventData) (int, error) {
+	fp, err := os.Open(name)
+	if err != nil {
+		return 0, err
+	}
+
+	defer fp.Close()
+
+	buffered := bufio.NewReader(fp)
+	input := (io.Reader)(buffered)
+
+	// Recognize gzip by its magic number rather than the file name.
+	if magic, err := buffered.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			return 0, err
+		}
+
+		defer gz.Close()
+		input = gz
+	}
+
+	reader := client.NewDumpReader(input)
+
+	for {
+		ev, err := reader.Next()
+		if err == io.EOF {
+			return reader.Count(), nil
+		} else if err != nil {
+			return reader.Count(), err
+		}
+
+		select {
+		case eventData <- ev:
+		case <-ctx.Done():
+			return reader.Count(), ctx.Err()
+		}
+	}
+}
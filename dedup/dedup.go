@@ -0,0 +1,292 @@
+// Package dedup removes duplicate records from a stream of Mixpanel event
+// data.
+//
+// Mixpanel's raw export contains duplicates, typically from clients retrying
+// requests with the same `$insert_id`. Since a product export can span
+// months, the set of keys seen so far is kept in memory only up to a limit,
+// and spilled to sorted files on disk beyond that. Each file has a bloom
+// filter in memory, so that the disk only needs to be consulted for likely
+// duplicates.
+package dedup
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"github.com/erik/mixport/mixpanel"
+)
+
+// DefaultMaxKeys is the number of keys a Set keeps in memory before spilling
+// them to disk, unless told otherwise. Roughly 20 bytes are used per key.
+const DefaultMaxKeys = 10000000
+
+// Set is a memory bounded set of 64 bit key fingerprints.
+//
+// Fingerprints are used instead of the keys themselves to save space. With
+// 64 bits, the chance of two distinct keys colliding stays negligible even
+// for hundreds of millions of records.
+type Set struct {
+	dir     string
+	maxKeys int
+
+	keys map[uint64]struct{}
+	runs []*run
+}
+
+// NewSet creates an empty Set keeping at most `maxKeys` keys in memory.
+// Spilled keys are written to temporary files in `dir`, or the system's
+// default temporary directory if empty.
+func NewSet(dir string, maxKeys int) *Set {
+	if maxKeys <= 0 {
+		maxKeys = DefaultMaxKeys
+	}
+
+	return &Set{
+		dir:     dir,
+		maxKeys: maxKeys,
+		keys:    make(map[uint64]struct{}),
+	}
+}
+
+// Add inserts the fingerprint into the set, returning false if it was
+// already present.
+func (s *Set) Add(fp uint64) (bool, error) {
+	if _, ok := s.keys[fp]; ok {
+		return false, nil
+	}
+
+	for _, r := range s.runs {
+		if found, err := r.contains(fp); err != nil {
+			return false, err
+		} else if found {
+			return false, nil
+		}
+	}
+
+	s.keys[fp] = struct{}{}
+
+	if len(s.keys) >= s.maxKeys {
+		if err := s.spill(); err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// spill writes the in-memory keys to a new run on disk.
+func (s *Set) spill() error {
+	keys := make([]uint64, 0, len(s.keys))
+	for fp := range s.keys {
+		keys = append(keys, fp)
+	}
+
+	r, err := newRun(s.dir, keys)
+	if err != nil {
+		return err
+	}
+
+	s.runs = append(s.runs, r)
+	s.keys = make(map[uint64]struct{})
+
+	return nil
+}
+
+// Close removes any files created by the Set.
+func (s *Set) Close() error {
+	var firstErr error
+
+	for _, r := range s.runs {
+		if err := r.close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	s.runs = nil
+	return firstErr
+}
+
+// run is a sorted file of fingerprints, with a bloom filter to avoid having
+// to search it for fingerprints it doesn't contain.
+type run struct {
+	file  *os.File
+	size  int
+	bloom bloom
+}
+
+// newRun sorts `keys` and writes them to a new temporary file.
+func newRun(dir string, keys []uint64) (*run, error) {
+	sort.Sort(fingerprints(keys))
+
+	fp, err := ioutil.TempFile(dir, "mixport-dedup-")
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create dedup file: %s", err)
+	}
+
+	r := &run{file: fp, size: len(keys), bloom: newBloom(len(keys))}
+
+	buf := make([]byte, 8*len(keys))
+	for i, key := range keys {
+		binary.BigEndian.PutUint64(buf[8*i:], key)
+		r.bloom.add(key)
+	}
+
+	if _, err := fp.Write(buf); err != nil {
+		r.close()
+		return nil, fmt.Errorf("couldn't write dedup file: %s", err)
+	}
+
+	return r, nil
+}
+
+// contains binary searches the run's file for the fingerprint.
+func (r *run) contains(fp uint64) (bool, error) {
+	if !r.bloom.test(fp) {
+		return false, nil
+	}
+
+	var (
+		buf     [8]byte
+		readErr error
+	)
+
+	i := sort.Search(r.size, func(i int) bool {
+		if readErr != nil {
+			return true
+		}
+
+		if _, readErr = r.file.ReadAt(buf[:], int64(8*i)); readErr != nil {
+			return true
+		}
+
+		return binary.BigEndian.Uint64(buf[:]) >= fp
+	})
+
+	if readErr != nil {
+		return false, fmt.Errorf("couldn't read dedup file: %s", readErr)
+	}
+
+	if i == r.size {
+		return false, nil
+	}
+
+	if _, err := r.file.ReadAt(buf[:], int64(8*i)); err != nil {
+		return false, fmt.Errorf("couldn't read dedup file: %s", err)
+	}
+
+	return binary.BigEndian.Uint64(buf[:]) == fp, nil
+}
+
+func (r *run) close() error {
+	r.file.Close()
+	return os.Remove(r.file.Name())
+}
+
+// fingerprints implements sort.Interface.
+type fingerprints []uint64
+
+func (f fingerprints) Len() int           { return len(f) }
+func (f fingerprints) Less(i, j int) bool { return f[i] < f[j] }
+func (f fingerprints) Swap(i, j int)      { f[i], f[j] = f[j], f[i] }
+
+// bloom is a simple bloom filter over fingerprints, using 10 bits per key
+// and 7 hash functions derived from the fingerprint itself, for a false
+// positive rate of about 1%.
+type bloom []uint64
+
+const bloomHashes = 7
+
+func newBloom(n int) bloom {
+	return make(bloom, (10*n+63)/64+1)
+}
+
+// positions calls `f` with each of the bit positions of the fingerprint.
+//
+// The fingerprint is scrambled first (using the splitmix64 finalizer) so that
+// similar fingerprints don't end up setting the same bits.
+func (b bloom) positions(fp uint64, f func(bit uint64)) {
+	fp ^= fp >> 30
+	fp *= 0xbf58476d1ce4e5b9
+	fp ^= fp >> 27
+	fp *= 0x94d049bb133111eb
+	fp ^= fp >> 31
+
+	h1, h2 := fp&0xffffffff, fp>>32|1
+	bits := uint64(64 * len(b))
+
+	for i := uint64(0); i < bloomHashes; i++ {
+		f((h1 + i*h2) % bits)
+	}
+}
+
+func (b bloom) add(fp uint64) {
+	b.positions(fp, func(bit uint64) {
+		b[bit/64] |= 1 << (bit % 64)
+	})
+}
+
+func (b bloom) test(fp uint64) bool {
+	found := true
+
+	b.positions(fp, func(bit uint64) {
+		if b[bit/64]&(1<<(bit%64)) == 0 {
+			found = false
+		}
+	})
+
+	return found
+}
+
+// Fingerprint computes the fingerprint of a record from the values of the
+// given keys, looked up with EventData.Lookup so that nested records work as
+// well. The second return value is false if the record is missing any of the
+// keys, in which case it can't be deduplicated.
+func Fingerprint(record mixpanel.EventData, keys []string) (uint64, bool) {
+	hash := fnv.New64a()
+
+	for _, key := range keys {
+		value, ok := record.Lookup(key)
+		if !ok || value == nil {
+			return 0, false
+		}
+
+		// Separate the values so ("ab", "c") and ("a", "bc") differ.
+		fmt.Fprintf(hash, "%v\x00", value)
+	}
+
+	return hash.Sum64(), true
+}
+
+// Filter passes records received on `records` through to `output`, dropping
+// any whose values for `keys` have been seen before. Records missing any of
+// the keys are always passed through.
+//
+// Returns once `records` is closed with the number of records dropped and
+// possibly an error from the underlying Set. After an error, records are no
+// longer deduplicated but still passed through, so that the sending side
+// isn't left hanging.
+func Filter(set *Set, keys []string, records <-chan mixpanel.EventData, output chan<- mixpanel.EventData) (int, error) {
+	var (
+		dropped int
+		err     error
+	)
+
+	for record := range records {
+		if fp, ok := Fingerprint(record, keys); ok && err == nil {
+			var added bool
+
+			if added, err = set.Add(fp); err == nil && !added {
+				dropped++
+				continue
+			}
+		}
+
+		output <- record
+	}
+
+	return dropped, err
+}
@@ -0,0 +1,124 @@
+package dedup
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/erik/mixport/mixpanel"
+)
+
+func TestSetSpills(t *testing.T) {
+	set := NewSet("", 10)
+	defer set.Close()
+
+	for i := uint64(0); i < 100; i++ {
+		if added, err := set.Add(i * 7919); err != nil {
+			t.Fatal(err)
+		} else if !added {
+			t.Errorf("expected %d to be new", i)
+		}
+	}
+
+	if len(set.runs) != 10 {
+		t.Errorf("expected 10 runs on disk, got %d", len(set.runs))
+	}
+
+	for i := uint64(0); i < 100; i++ {
+		if added, err := set.Add(i * 7919); err != nil {
+			t.Fatal(err)
+		} else if added {
+			t.Errorf("expected %d to be a duplicate", i)
+		}
+	}
+
+	if added, _ := set.Add(1); !added {
+		t.Error("expected unseen key to be added")
+	}
+}
+
+func TestBloom(t *testing.T) {
+	b := newBloom(1000)
+
+	for i := uint64(0); i < 1000; i++ {
+		b.add(i)
+	}
+
+	for i := uint64(0); i < 1000; i++ {
+		if !b.test(i) {
+			t.Fatalf("false negative for %d", i)
+		}
+	}
+
+	falsePositives := 0
+	for i := uint64(1000); i < 11000; i++ {
+		if b.test(i) {
+			falsePositives++
+		}
+	}
+
+	if falsePositives > 300 {
+		t.Errorf("too many false positives: %d/10000", falsePositives)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	records := make(chan mixpanel.EventData, 10)
+	output := make(chan mixpanel.EventData, 10)
+
+	for _, id := range []string{"a", "b", "a", "c", "b"} {
+		records <- mixpanel.EventData{"$insert_id": id}
+	}
+
+	// Records without the key are never dropped.
+	records <- mixpanel.EventData{"event": "x"}
+	records <- mixpanel.EventData{"event": "x"}
+	close(records)
+
+	set := NewSet("", 0)
+	defer set.Close()
+
+	dropped, err := Filter(set, []string{"$insert_id"}, records, output)
+	if err != nil {
+		t.Fatal(err)
+	} else if dropped != 2 {
+		t.Errorf("expected 2 duplicates, got %d", dropped)
+	}
+	close(output)
+
+	var seen []string
+	for record := range output {
+		seen = append(seen, fmt.Sprintf("%v", record["$insert_id"]))
+	}
+
+	if fmt.Sprint(seen) != "[a b c <nil> <nil>]" {
+		t.Errorf("unexpected output: %v", seen)
+	}
+}
+
+func TestFingerprintTuple(t *testing.T) {
+	keys := []string{"a", "b"}
+
+	x, _ := Fingerprint(mixpanel.EventData{"a": "ab", "b": "c"}, keys)
+	y, _ := Fingerprint(mixpanel.EventData{"a": "a", "b": "bc"}, keys)
+
+	if x == y {
+		t.Error("expected different tuples to differ")
+	}
+
+	if _, ok := Fingerprint(mixpanel.EventData{"a": "ab"}, keys); ok {
+		t.Error("expected record missing a key to have no fingerprint")
+	}
+}
+
+func TestFingerprintNested(t *testing.T) {
+	keys := []string{"$insert_id"}
+
+	flat, _ := Fingerprint(mixpanel.EventData{"$insert_id": "x"}, keys)
+	nested, ok := Fingerprint(mixpanel.EventData{
+		"properties": map[string]interface{}{"$insert_id": "x"},
+	}, keys)
+
+	if !ok || flat != nested {
+		t.Error("expected nested records to be fingerprinted by their properties")
+	}
+}
@@ -0,0 +1,254 @@
+// Package download splits long date ranges of event data into chunks of
+// days, which are downloaded several at a time.
+//
+// Records of concurrently downloaded chunks arrive interleaved, unless the
+// records are requested in order. In that case each chunk is spooled to a
+// temporary file, and the files are replayed strictly in date order as the
+// chunks complete.
+package download
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/erik/mixport/mixpanel"
+)
+
+// Options controls how a date range is split up and downloaded.
+//
+// - `Window` is the number of days in each chunk. Defaults to 1.
+// - `Concurrency` is the number of chunks downloaded at the same time. With
+//   1 or less, the whole range is handed to the ExportFunc at once, so that
+//   it can pick and adjust the window sizes on its own.
+// - `Ordered` makes sure the records are sent in date order even when
+//   downloading concurrently.
+type Options struct {
+	Window      int
+	Concurrency int
+	Ordered     bool
+}
+
+// ExportFunc downloads the records of the days from `start` to `end`
+// (inclusive), sending them on `output`. Returns the number of records sent.
+// See mixpanel.ExportRange.
+type ExportFunc func(ctx context.Context, start, end time.Time, output chan<- mixpanel.EventData) (int, error)
+
+// Range downloads every day from `start` to `end` (inclusive) with `export`,
+// sending the records on `output`, and returns the number of records
+// downloaded.
+//
+// The first failing chunk cancels all of the others, and its error is
+// returned.
+func Range(ctx context.Context, start, end time.Time, opts Options, export ExportFunc, output chan<- mixpanel.EventData) (int, error) {
+	concurrency := opts.Concurrency
+
+	if concurrency <= 1 {
+		return export(ctx, start, end, output)
+	}
+
+	window := opts.Window
+	if window < 1 {
+		window = 1
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu       sync.Mutex
+		total    int
+		firstErr error
+		wg       sync.WaitGroup
+	)
+
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+	}
+
+	slots := make(chan struct{}, concurrency)
+
+	// Spools of each chunk, in date order, when ordering is requested.
+	spools := make(chan *spool, concurrency)
+
+	var merged sync.WaitGroup
+	if opts.Ordered {
+		merged.Add(1)
+		go func() {
+			defer merged.Done()
+
+			for sp := range spools {
+				if err := sp.replay(ctx, output); err != nil {
+					fail(err)
+				}
+			}
+		}()
+	}
+
+	for from := start; !from.After(end); from = from.AddDate(0, 0, window) {
+		to := from.AddDate(0, 0, window-1)
+		if to.After(end) {
+			to = end
+		}
+
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+
+		if ctx.Err() != nil {
+			break
+		}
+
+		var sp *spool
+		if opts.Ordered {
+			var err error
+			if sp, err = newSpool(); err != nil {
+				fail(err)
+				<-slots
+				break
+			}
+
+			spools <- sp
+		}
+
+		wg.Add(1)
+		go func(from, to time.Time) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			var (
+				num int
+				err error
+			)
+
+			if sp != nil {
+				num, err = sp.record(func(records chan<- mixpanel.EventData) (int, error) {
+					return export(ctx, from, to, records)
+				})
+			} else {
+				num, err = export(ctx, from, to, output)
+			}
+
+			mu.Lock()
+			total += num
+			mu.Unlock()
+
+			if err != nil {
+				fail(err)
+			}
+		}(from, to)
+	}
+
+	wg.Wait()
+	close(spools)
+	merged.Wait()
+
+	if firstErr == nil && ctx.Err() != nil {
+		firstErr = ctx.Err()
+	}
+
+	return total, firstErr
+}
+
+// spool buffers the records of a single chunk of an ordered download in a
+// temporary file until it's that chunk's turn to be written out.
+type spool struct {
+	file *os.File
+	done chan struct{}
+	err  error
+}
+
+// newSpool creates an empty spool backed by a new temporary file.
+func newSpool() (*spool, error) {
+	fp, err := ioutil.TempFile("", "mixport-spool-")
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create spool file: %s", err)
+	}
+
+	return &spool{file: fp, done: make(chan struct{})}, nil
+}
+
+// record runs `export`, writing the records it produces to the spool file.
+func (sp *spool) record(export func(chan<- mixpanel.EventData) (int, error)) (int, error) {
+	defer close(sp.done)
+
+	records := make(chan mixpanel.EventData, 100)
+	written := make(chan error, 1)
+
+	go func() {
+		writer := bufio.NewWriter(sp.file)
+		encoder := json.NewEncoder(writer)
+
+		for record := range records {
+			// Keep the bytes of raw records intact by storing
+			// them as a string, see replay.
+			if raw, ok := record.Raw(); ok {
+				record[mixpanel.RawKey] = string(raw)
+			}
+
+			encoder.Encode(record)
+		}
+
+		written <- writer.Flush()
+	}()
+
+	num, err := export(records)
+	close(records)
+
+	if werr := <-written; err == nil && werr != nil {
+		err = fmt.Errorf("couldn't write spool file: %s", werr)
+	}
+
+	sp.err = err
+	return num, err
+}
+
+// replay waits for the spool to be recorded, then sends its records on
+// `output` and removes the spool file. Nothing is sent if recording failed or
+// `ctx` has been cancelled in the meantime.
+func (sp *spool) replay(ctx context.Context, output chan<- mixpanel.EventData) error {
+	<-sp.done
+
+	defer os.Remove(sp.file.Name())
+	defer sp.file.Close()
+
+	if sp.err != nil || ctx.Err() != nil {
+		return nil
+	}
+
+	if _, err := sp.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(bufio.NewReader(sp.file))
+	decoder.UseNumber()
+
+	for {
+		var data mixpanel.EventData
+
+		if err := decoder.Decode(&data); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("couldn't read spool file: %s", err)
+		}
+
+		if raw, ok := data[mixpanel.RawKey].(string); ok {
+			data[mixpanel.RawKey] = json.RawMessage(raw)
+		}
+
+		output <- data
+	}
+}
@@ -0,0 +1,133 @@
+package download
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/erik/mixport/mixpanel"
+)
+
+// exportDays sends one record per day, named after it. The first days are
+// the slowest, so that concurrent chunks finish in reverse order.
+func exportDays(last time.Time) ExportFunc {
+	return func(ctx context.Context, start, end time.Time, output chan<- mixpanel.EventData) (int, error) {
+		time.Sleep(time.Duration(last.Sub(start).Hours()/24) * 10 * time.Millisecond)
+
+		num := 0
+		for day := start; !day.After(end); day = day.AddDate(0, 0, 1) {
+			output <- mixpanel.EventData{"day": day.Format("2006-01-02")}
+			num++
+		}
+
+		return num, nil
+	}
+}
+
+func TestRangeOrdered(t *testing.T) {
+	start, _ := time.Parse("2006-01-02", "2014-01-01")
+	end := start.AddDate(0, 0, 5)
+
+	opts := Options{Window: 1, Concurrency: 4, Ordered: true}
+	output := make(chan mixpanel.EventData, 10)
+
+	if num, err := Range(context.Background(), start, end, opts, exportDays(end), output); err != nil {
+		t.Fatalf("raised error: %v", err)
+	} else if num != 6 {
+		t.Errorf("expected 6 records, got %d", num)
+	}
+	close(output)
+
+	day := start
+	for ev := range output {
+		if expected := day.Format("2006-01-02"); ev["day"] != expected {
+			t.Errorf("expected %s, got %v", expected, ev["day"])
+		}
+		day = day.AddDate(0, 0, 1)
+	}
+
+	if !day.After(end) {
+		t.Errorf("expected records up to %s, stopped at %s", end, day)
+	}
+}
+
+func TestRangeFailingChunk(t *testing.T) {
+	start, _ := time.Parse("2006-01-02", "2014-01-01")
+	end := start.AddDate(0, 0, 4)
+	failing := start.AddDate(0, 0, 2)
+
+	broken := errors.New("broken")
+
+	// The failing chunk has to cancel the others, which would never
+	// finish otherwise.
+	export := func(ctx context.Context, start, end time.Time, output chan<- mixpanel.EventData) (int, error) {
+		if start.Equal(failing) {
+			return 0, broken
+		}
+
+		<-ctx.Done()
+		return 0, ctx.Err()
+	}
+
+	for _, ordered := range []bool{false, true} {
+		opts := Options{Window: 1, Concurrency: 5, Ordered: ordered}
+		output := make(chan mixpanel.EventData, 10)
+
+		if _, err := Range(context.Background(), start, end, opts, export, output); err != broken {
+			t.Errorf("ordered=%t: expected the chunk's error, got %v", ordered, err)
+		}
+
+		if len(output) != 0 {
+			t.Errorf("ordered=%t: expected no records, got %d", ordered, len(output))
+		}
+	}
+}
+
+func TestSpoolRoundTrip(t *testing.T) {
+	sp, err := newSpool()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw := json.RawMessage(`{"event":"e","properties":{"n":1.50}}`)
+
+	records := []mixpanel.EventData{
+		{"event": "e", "n": json.Number("12345678901234567890"), "f": json.Number("1.50")},
+		{mixpanel.RawKey: raw},
+	}
+
+	_, err = sp.record(func(output chan<- mixpanel.EventData) (int, error) {
+		for _, record := range records {
+			output <- record
+		}
+		return len(records), nil
+	})
+	if err != nil {
+		t.Fatalf("raised error: %v", err)
+	}
+
+	output := make(chan mixpanel.EventData, 10)
+	if err := sp.replay(context.Background(), output); err != nil {
+		t.Fatalf("raised error: %v", err)
+	}
+	close(output)
+
+	if len(output) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(output))
+	}
+
+	ev := <-output
+	for key, expected := range map[string]json.Number{"n": "12345678901234567890", "f": "1.50"} {
+		if n, ok := ev[key].(json.Number); !ok || n != expected {
+			t.Errorf("%s: expected json.Number %s, got %#v", key, expected, ev[key])
+		}
+	}
+
+	ev = <-output
+	if got, ok := ev.Raw(); !ok || string(got) != string(raw) {
+		t.Errorf("expected raw record %s, got %s", raw, fmt.Sprint(ev[mixpanel.RawKey]))
+	}
+}
@@ -73,3 +73,24 @@ func CSVColumnStreamer(defs map[string]EventColumnDef, records <-chan mixpanel.E
 		def.writer.Flush()
 	}
 }
+
+// MergeColumns adds the columns that aren't part of `existing` yet to its
+// end, in the order given. The order of `existing` is left untouched, so that
+// hand picked column orders survive regenerating the definitions.
+func MergeColumns(existing, columns []string) []string {
+	merged := append([]string(nil), existing...)
+
+	seen := make(map[string]bool, len(existing))
+	for _, col := range existing {
+		seen[col] = true
+	}
+
+	for _, col := range columns {
+		if !seen[col] {
+			merged = append(merged, col)
+			seen[col] = true
+		}
+	}
+
+	return merged
+}
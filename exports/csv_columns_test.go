@@ -7,6 +7,7 @@ import (
 	"github.com/erik/mixport/mixpanel"
 	"io/ioutil"
 	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -70,6 +71,31 @@ func TestCSVColumnStreamer(t *testing.T) {
 	}
 }
 
+func TestCSVColumnStreamerRenamed(t *testing.T) {
+	mix := mixpanel.New("product", "", "")
+	mix.Collisions = mixpanel.RenameCollisions
+
+	input := strings.NewReader(`{"event": "a", "properties": {"event": 1, "product": 2, "$__$$event_id": 3}}`)
+	records := make(chan mixpanel.EventData, 1)
+
+	if _, err := mix.TransformEventData(input, records); err != nil {
+		t.Fatalf("raised error: %v", err)
+	}
+	close(records)
+
+	buf := new(bytes.Buffer)
+	defs := map[string]EventColumnDef{
+		"a": NewEventColumnDef(buf, []string{"original_event", "product", "_mixport_product", "original_$__$$event_id"}),
+	}
+
+	CSVColumnStreamer(defs, records)
+
+	expected := "original_event,product,_mixport_product,original_$__$$event_id\n1,2,product,3\n"
+	if buf.String() != expected {
+		t.Errorf("got (%s), expected (%s)", buf.String(), expected)
+	}
+}
+
 func BenchmarkCSVColumnStreamer(b *testing.B) {
 	columns := [][]string{
 		{"a0", "b0", "c0", "d0"},
@@ -104,3 +130,20 @@ func BenchmarkCSVColumnStreamer(b *testing.B) {
 	b.ResetTimer()
 	CSVColumnStreamer(defs, records)
 }
+
+func TestMergeColumns(t *testing.T) {
+	existing := []string{"c", "a"}
+	merged := MergeColumns(existing, []string{"a", "b", "c", "d"})
+
+	if fmt.Sprint(merged) != "[c a b d]" {
+		t.Errorf("expected existing order to be kept, got %v", merged)
+	}
+
+	if fmt.Sprint(existing) != "[c a]" {
+		t.Errorf("expected existing columns to be left alone, got %v", existing)
+	}
+
+	if merged := MergeColumns(nil, []string{"a", "a"}); fmt.Sprint(merged) != "[a]" {
+		t.Errorf("expected duplicates to be dropped, got %v", merged)
+	}
+}
@@ -11,10 +11,19 @@ import (
 //
 // Format is simply: `{"key": "value", ...}`. `value` is usually scalar, but
 // can be any valid JSON type.
+//
+// Raw records (see mixpanel.RawRecords) are written exactly as they were
+// received from Mixpanel instead.
 func JSONStreamer(w io.Writer, records <-chan mixpanel.EventData) {
 	encoder := json.NewEncoder(w)
 
 	for record := range records {
+		if raw, ok := record.Raw(); ok {
+			w.Write(raw)
+			io.WriteString(w, "\n")
+			continue
+		}
+
 		encoder.Encode(record)
 	}
 }
@@ -37,6 +37,25 @@ func TestJSONtreamer(t *testing.T) {
 	}
 }
 
+func TestJSONStreamerRaw(t *testing.T) {
+	var output bytes.Buffer
+
+	raw := `{"event":"a","properties":{"url":"a<b>"}}`
+
+	records := make(chan mixpanel.EventData, 1)
+	records <- mixpanel.EventData{
+		"event":         "a",
+		mixpanel.RawKey: json.RawMessage(raw),
+	}
+	close(records)
+
+	JSONStreamer(&output, records)
+
+	if output.String() != raw+"\n" {
+		t.Errorf("got (%s), expected (%s)", output.String(), raw)
+	}
+}
+
 func BenchmarkJSONStreamer(b *testing.B) {
 	records := make(chan mixpanel.EventData, b.N)
 
@@ -2,11 +2,14 @@ package main
 
 import (
 	"compress/gzip"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
 	"path"
 	"runtime"
 	"runtime/pprof"
@@ -15,17 +18,110 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/erik/mixport/dedup"
+	"github.com/erik/mixport/download"
 	"github.com/erik/mixport/exports"
 	"github.com/erik/mixport/mixpanel"
 	flag "github.com/ogier/pflag"
 	"gopkg.in/gcfg.v1"
 )
 
-// Mixpanel API credentials, used by the configuration parser.
-type mixpanelCredentials struct {
-	Key    string
-	Secret string
-	Token  string
+// productConfig holds the Mixpanel API credentials and per-product export
+// settings, used by the configuration parser.
+//
+// - `Auth` selects the authentication scheme: "signature" (the default, using
+//   `Key` and `Secret`), "projectsecret" (using `Secret`) or "serviceaccount"
+//   (using `Username`, `Secret` and `ProjectID`).
+// - `Region` is the data residency region ("us", "eu" or "in") the project's
+//   data is stored in. `BaseURL` overrides the export endpoint entirely.
+// - `Event`, `Where` and `Limit` restrict which records are exported, see
+//   mixpanel.ExportOptions. `Event` may be given multiple times.
+// - `Window` is the maximum number of days downloaded in a single request.
+//   Defaults to 1, see mixpanel.ExportRange.
+// - `Concurrency` is the number of requests for this product that may be
+//   downloading at once, each covering up to `Window` days. If `Ordered` is
+//   set, records are still written out in date order.
+// - `Timezone` is the project's timezone (e.g. "America/Los_Angeles"), as
+//   configured in Mixpanel. Defaults to UTC. Dates to export are interpreted
+//   in this timezone.
+// - `TimestampFormat` is one of "sql" (the default), "sqlms", "rfc3339" or
+//   "epochms". If `LocalTimestamp` is set, each record gets a timestamp in
+//   the project's timezone in addition to the UTC one.
+// - `Dedup` drops records whose `DedupKey` properties (`$insert_id` by
+//   default, may be given multiple times) match an earlier record's within
+//   the run. At most `DedupMaxKeys` keys are kept in memory, the rest are
+//   spilled to temporary files.
+// - `Transform` selects the shape of the exported records: "flatten" (the
+//   default), "nested" or "raw". See mixpanel.TransformMode. Only the JSON
+//   export supports the latter two.
+// - `Collisions` decides what happens to properties named like the keys added
+//   by flattening: "overwrite" (the default), "prefix", "fail" or "rename".
+//   See mixpanel.CollisionPolicy.
+// - `IDs` selects how event IDs are generated: "random" (the default),
+//   "insertid" or "content". See mixpanel.IDStrategy.
+// - `Profiles` additionally exports the product's people profiles, to files
+//   named PRODUCT-profiles-DATE. `EngageURL`, `CohortsURL` and `AppURL`
+//   override the Engage, cohort listing and app API endpoints.
+// - `Retries` is the number of times a failed request is retried. If unset,
+//   the mixpanel package's default policy is used.
+// - `RetryWait` and `RetryMaxWait` bound the backoff between two attempts.
+// - `Rejects` is a directory to write malformed records to, as
+//   PRODUCT-DATE.rejects, rather than failing the export on the first one.
+//   The export still fails once more than `MaxRejects` records, or more than
+//   `MaxRejectRatio` of them, have been rejected. See mixpanel.RejectPolicy.
+type productConfig struct {
+	Key        string
+	Secret     string
+	Token      string
+	Auth       string
+	Username   string
+	ProjectID  string
+	Region     string
+	BaseURL    string
+	EngageURL  string
+	CohortsURL string
+	AppURL     string
+
+	Event []string
+	Where string
+	Limit int
+
+	Window      int
+	Concurrency int
+	Ordered     bool
+
+	Transform  string
+	Collisions string
+	IDs        string
+
+	Timezone        string
+	TimestampFormat string
+	LocalTimestamp  bool
+
+	Dedup        bool
+	DedupKey     []string
+	DedupMaxKeys int
+
+	Profiles bool
+
+	Retries      *int
+	RetryWait    duration
+	RetryMaxWait duration
+
+	Rejects        string
+	MaxRejects     int
+	MaxRejectRatio float64
+}
+
+// duration wraps time.Duration so that it can be read from the configuration
+// file in the "1m30s" format understood by time.ParseDuration.
+type duration time.Duration
+
+// UnmarshalText implements encoding.TextUnmarshaler for gcfg.
+func (d *duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	*d = duration(v)
+	return err
 }
 
 // fileExportConfig contains configuration options common to the file export
@@ -48,19 +144,52 @@ type columnExportConfig struct {
 	Columns string
 }
 
+// httpConfig contains the transport level settings shared by all of the
+// Mixpanel API clients.
+//
+// - `ConnectTimeout` and `IdleTimeout` bound connecting to the API and the
+//   time a download may stall without receiving data.
+// - `Proxy` is the URL of an HTTP(S) proxy to connect through.
+// - `CAFile` is a PEM bundle of extra certificate authorities to trust.
+type httpConfig struct {
+	ConnectTimeout duration
+	IdleTimeout    duration
+	Proxy          string
+	CAFile         string
+}
+
+// mixpanelConfig contains the process wide limits on Mixpanel API usage,
+// shared by all product exports.
+//
+// - `MaxConcurrent` is the maximum number of requests in progress at once.
+// - `MaxPerHour` is the maximum number of requests started in any hour.
+type mixpanelConfig struct {
+	MaxConcurrent int
+	MaxPerHour    int
+}
+
 // configFormat is the in-memory representation of the mixport configuration
 // file.
 //
-// - `Product` is Mixpanel API credential information for each product that
-//   will be exported.
+// - `Product` is Mixpanel API credential information and export settings for
+//   each product that will be exported.
+// - `Default_Product` holds the settings shared by all products, given in
+//   the `[default-product]` section. Individual products can override them.
 // - `JSON` and `CSV` are the configuration setups for the `JSON` and `CSV`
 //   exporters, respectively.
 // - `Columns` is the configuration for the `CSV column` export type.
+// - `Cohorts` is the configuration for the cohort membership export.
+// - `HTTP` configures how the Mixpanel API is reached.
+// - `Mixpanel` limits the rate of requests made to the API.
 type configFormat struct {
-	Product map[string]*mixpanelCredentials
-	JSON    fileExportConfig
-	CSV     fileExportConfig
-	Columns columnExportConfig
+	Product         map[string]*productConfig
+	Default_Product productConfig
+	JSON            fileExportConfig
+	CSV             fileExportConfig
+	Columns         columnExportConfig
+	Cohorts         fileExportConfig
+	HTTP            httpConfig
+	Mixpanel        mixpanelConfig
 }
 
 // exportConfig simply bundles together the variables describing the export of
@@ -68,33 +197,99 @@ type configFormat struct {
 // signatures.
 type exportConfig struct {
 	Product    string
-	Creds      mixpanelCredentials
+	Conf       productConfig
+	Options    mixpanel.ExportOptions
 	Start, End time.Time
 }
 
 // Holds parsed configuration file
 var cfg = configFormat{}
 
-// Map containing the names of the product exports that failed. For deletion
-// and error reporting purposes.
+// HTTP client shared by all of the product exports, built from `cfg.HTTP`.
+var httpClient *http.Client
+
+// Rate limiter shared by all of the product exports, built from
+// `cfg.Mixpanel`.
+var limiter *mixpanel.Limiter
+
+// Directories API responses are recorded to or replayed from, see `--record`
+// and `--replay`.
+var recordDir, replayDir string
+
+// Map containing the names of the exports that failed, see exportName. For
+// deletion and error reporting purposes.
 var failedExports = make(map[string]bool)
 
+// Guards failedExports, which is written to by the concurrently running
+// product exports.
+var failedMu sync.Mutex
+
+// exportName names the export of one kind of a product's records: "PRODUCT"
+// for its events, "PRODUCT KIND" for anything else (e.g. "profiles").
+func exportName(product, kind string) string {
+	if kind == "" {
+		return product
+	}
+
+	return product + " " + kind
+}
+
+// markFailed records that the named export failed.
+func markFailed(name string) {
+	failedMu.Lock()
+	defer failedMu.Unlock()
+
+	failedExports[name] = true
+}
+
+// hasFailed reports whether the named export failed.
+func hasFailed(name string) bool {
+	failedMu.Lock()
+	defer failedMu.Unlock()
+
+	return failedExports[name]
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "columns" {
+		columnsCommand(os.Args[2:])
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "convert" {
+		convertCommand(os.Args[2:])
+		return
+	}
+
 	flag.Usage = func() {
 		fmt.Println(`Usage: mixport [OPTIONS]
+       mixport columns generate [OPTIONS]
+       mixport convert [OPTIONS] FILE...
 
 Download and transform Mixpanel event data.
 
 Options:
   -h, --help      Display this message.
   -c, --config    Path to configuration file, defaulting to "./mixport.conf"
-  -d, --date      Date of data to pull in YYYY/MM/DD, defaulting to yesterday.
+  -d, --date      Date of data to pull in YYYY/MM/DD, defaulting to yesterday
+                  in each product's timezone.
   -p, --procs     Maximum number of OS threads for the go runtime to use,
                   defaulting to number of CPUs available on the machine.
   --products      Comma separated list of products to export.
   --prof          Dump pprof info to the named file.
   -r, --range     Specify a date range to pull data for in YYYY/MM/DD-YYYY/MM/DD
-                  format.`)
+                  format.
+  --events        Comma separated list of event names to export, overriding
+                  the configuration.
+  --where         Only export records matching this Mixpanel expression,
+                  overriding the configuration.
+  --limit         Maximum number of records to export per request.
+  --wait-until-complete
+                  Skip products for which the days to export haven't fully
+                  elapsed yet in the product's timezone.
+  --record        Save the API responses to the named directory.
+  --replay        Read the API responses from the named directory, as saved
+                  by --record, instead of downloading them.`)
 	}
 
 	var (
@@ -104,10 +299,21 @@ Options:
 		cpuProfile  = flag.String("prof", "", "")
 		productList = flag.String("products", "", "")
 		maxProcs    = flag.IntP("procs", "p", runtime.NumCPU(), "")
+		eventList   = flag.String("events", "", "")
+		whereExpr   = flag.String("where", "", "")
+		limit       = flag.Int("limit", 0, "")
+		waitForDay  = flag.Bool("wait-until-complete", false, "")
 	)
 
+	flag.StringVar(&recordDir, "record", "", "")
+	flag.StringVar(&replayDir, "replay", "", "")
+
 	flag.Parse()
 
+	if recordDir != "" && replayDir != "" {
+		log.Fatalf("Can't have both --record and --replay")
+	}
+
 	runtime.GOMAXPROCS(*maxProcs)
 
 	if *cpuProfile != "" {
@@ -123,30 +329,155 @@ Options:
 		log.Fatalf("Failed to load %s: %s", *configFile, err)
 	}
 
-	var exportStart, exportEnd time.Time
+	// Default to yesterday (should be newest available data). What
+	// yesterday is depends on each product's timezone, so that's resolved
+	// later on.
+	useYesterday := *dateString == "" && *rangeString == ""
+
+	exportStart, exportEnd := parseDates(*dateString, *rangeString)
+
+	checkFileConfigs()
+	setupAPI()
 
-	// Default to yesterday (should be newest available data)
-	if *dateString == "" {
-		year, month, day := time.Now().UTC().AddDate(0, 0, -1).Date()
+	products := make(map[string]*productConfig)
 
-		exportStart = time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
-		exportEnd = exportStart
+	// If not explicitly specified, export all products in the config
+	if *productList == "" {
+		products = cfg.Product
 	} else {
-		if d, err := time.Parse("2006/01/02", *dateString); err != nil {
+		for _, name := range strings.Split(*productList, ",") {
+			if conf, ok := cfg.Product[name]; ok {
+				products[name] = conf
+			} else {
+				log.Fatalf("Don't have credentials specified for %s", name)
+			}
+		}
+	}
+
+	ctx, cancel := cancelOnSignal()
+	defer cancel()
+
+	// WaitGroup will hold the process open until all of the child
+	// goroutines have completed execution.
+	var wg sync.WaitGroup
+
+	type pendingExport struct {
+		export exportConfig
+		client *mixpanel.Mixpanel
+
+		// A separate client for the profiles, if enabled, so that
+		// collisions are counted separately.
+		profiles *mixpanel.Mixpanel
+	}
+
+	var pending []pendingExport
+
+	// Set up and sanity check all of the exports before starting any of
+	// them.
+	for product, conf := range products {
+		export := exportConfig{
+			Product: product,
+			Conf:    *conf,
+			Options: mixpanel.ExportOptions{
+				Events: conf.Event,
+				Where:  conf.Where,
+				Limit:  conf.Limit,
+			},
+		}
+
+		// Command line arguments take precedence over the configuration.
+		if *eventList != "" {
+			export.Options.Events = strings.Split(*eventList, ",")
+		}
+
+		if *whereExpr != "" {
+			export.Options.Where = *whereExpr
+		}
+
+		if *limit > 0 {
+			export.Options.Limit = *limit
+		}
+
+		client, err := newClient(export)
+		if err != nil {
+			log.Fatalf("%s: %s", product, err)
+		}
+
+		// Mixpanel interprets dates in the project's timezone.
+		loc := time.UTC
+		if client.Location != nil {
+			loc = client.Location
+		}
+
+		if useYesterday {
+			export.Start = midnight(time.Now().In(loc).AddDate(0, 0, -1), loc)
+			export.End = export.Start
+		} else {
+			export.Start = midnight(exportStart, loc)
+			export.End = midnight(exportEnd, loc)
+		}
+
+		// Other products may well be in a timezone where the day is
+		// over already, so only skip this one.
+		if *waitForDay && time.Now().Before(export.End.AddDate(0, 0, 1)) {
+			log.Printf("%s: %s hasn't ended yet in %s, skipping the product.",
+				product, export.End.Format("2006-01-02"), loc)
+			markFailed(product)
+			continue
+		}
+
+		p := pendingExport{export: export, client: client}
+
+		if conf.Profiles {
+			if p.profiles, err = newClient(export); err != nil {
+				log.Fatalf("%s: %s", product, err)
+			}
+		}
+
+		pending = append(pending, p)
+	}
+
+	// Run each individual product export in a new goroutine.
+	for _, p := range pending {
+		wg.Add(1)
+		go exportProduct(ctx, p.export, p.client, &wg)
+
+		if p.profiles != nil {
+			wg.Add(1)
+			go exportProfiles(ctx, p.export, p.profiles, &wg)
+		}
+
+		if cfg.Cohorts.State {
+			wg.Add(1)
+			go exportCohorts(ctx, p.export, p.client, &wg)
+		}
+	}
+
+	// Wait for all our goroutines to finish up
+	wg.Wait()
+
+	exitIfFailed()
+}
+
+// parseDates parses the `--date` and `--range` arguments into the first and
+// last day to export, both zero if neither is given.
+func parseDates(dateString, rangeString string) (start, end time.Time) {
+	if dateString != "" {
+		if d, err := time.Parse("2006/01/02", dateString); err != nil {
 			log.Fatalf("Invalid date: %s, should be in YYYY/MM/DD format",
-				*dateString)
+				dateString)
 		} else {
-			exportStart = d
-			exportEnd = exportStart
+			start = d
+			end = start
 		}
 	}
 
-	if *rangeString != "" {
+	if rangeString != "" {
 		formatError := func() {
-			log.Fatalf("Invalid range: %s, should be YYYY/MM/DD-YYYY/MM/DD.", *rangeString)
+			log.Fatalf("Invalid range: %s, should be YYYY/MM/DD-YYYY/MM/DD.", rangeString)
 		}
 
-		parts := strings.Split(*rangeString, "-")
+		parts := strings.Split(rangeString, "-")
 
 		if len(parts) != 2 {
 			formatError()
@@ -154,70 +485,224 @@ Options:
 
 		var err error
 
-		if exportStart, err = time.Parse("2006/01/02", parts[0]); err != nil {
+		if start, err = time.Parse("2006/01/02", parts[0]); err != nil {
 			formatError()
 		}
 
-		if exportEnd, err = time.Parse("2006/01/02", parts[1]); err != nil {
+		if end, err = time.Parse("2006/01/02", parts[1]); err != nil {
 			formatError()
 		}
-
 	}
 
-	// Do some sanity checking on each of the file configs.
-	fileConfigs := []fileExportConfig{cfg.CSV, cfg.JSON, cfg.Columns.fileExportConfig}
+	return start, end
+}
+
+// checkFileConfigs does some sanity checking on each of the file configs.
+func checkFileConfigs() {
+	fileConfigs := []fileExportConfig{cfg.CSV, cfg.JSON, cfg.Columns.fileExportConfig, cfg.Cohorts}
 	for _, config := range fileConfigs {
 		if config.State && config.Fifo && config.RemoveFailed {
 			log.Fatalf("Can't have both `fifo=true` and `removefailed=true`")
 		}
 	}
+}
 
-	products := make(map[string]*mixpanelCredentials)
+// cancelOnSignal returns a context that is cancelled on SIGINT or SIGTERM, so
+// that running exports can clean up their partial output properly. A second
+// signal kills the process immediately.
+func cancelOnSignal() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
 
-	// If not explicitly specified, export all products in the config
-	if *productList == "" {
-		products = cfg.Product
-	} else {
-		for _, name := range strings.Split(*productList, ",") {
-			if creds, ok := cfg.Product[name]; ok {
-				products[name] = creds
-			} else {
-				log.Fatalf("Don't have credentials specified for %s", name)
-			}
-		}
-	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
 
-	// WaitGroup will hold the process open until all of the child
-	// goroutines have completed execution.
-	var wg sync.WaitGroup
+	go func() {
+		sig := <-signals
+		signal.Stop(signals)
 
-	// Run each individual product export in a new goroutine.
-	for product, creds := range products {
-		wg.Add(1)
-		go exportProduct(exportConfig{product, *creds, exportStart, exportEnd}, &wg)
-	}
+		log.Printf("Received %s, cancelling exports.", sig)
+		cancel()
+	}()
 
-	// Wait for all our goroutines to finish up
-	wg.Wait()
+	return ctx, cancel
+}
 
+// exitIfFailed lists the failed exports, if any, and exits with an
+// error status.
+func exitIfFailed() {
 	if len(failedExports) > 0 {
 		log.Printf("Finished with errors:")
-		for product := range failedExports {
-			log.Printf("\t%s", product)
+		for name := range failedExports {
+			log.Printf("\t%s", name)
 		}
 		os.Exit(1)
 	}
 }
 
+// setupAPI creates the HTTP client and rate limiter shared by all of the
+// Mixpanel API clients, according to the configuration.
+func setupAPI() {
+	httpOpts := mixpanel.DefaultHTTPOptions
+	httpOpts.Proxy = cfg.HTTP.Proxy
+	httpOpts.CAFile = cfg.HTTP.CAFile
+
+	if cfg.HTTP.ConnectTimeout > 0 {
+		httpOpts.ConnectTimeout = time.Duration(cfg.HTTP.ConnectTimeout)
+	}
+
+	if cfg.HTTP.IdleTimeout > 0 {
+		httpOpts.IdleTimeout = time.Duration(cfg.HTTP.IdleTimeout)
+	}
+
+	var err error
+	if httpClient, err = mixpanel.NewHTTPClient(httpOpts); err != nil {
+		log.Fatalf("Invalid [http] configuration: %s", err)
+	}
+
+	limiter = mixpanel.NewLimiter(cfg.Mixpanel.MaxConcurrent, cfg.Mixpanel.MaxPerHour)
+}
+
+// midnight returns the start of the given date in `loc`.
+func midnight(date time.Time, loc *time.Location) time.Time {
+	year, month, day := date.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, loc)
+}
+
+// newClient creates a Mixpanel API client for the given product export,
+// applying the settings from its configuration section.
+func newClient(export exportConfig) (*mixpanel.Mixpanel, error) {
+	conf := export.Conf
+	client := mixpanel.New(export.Product, conf.Key, conf.Secret)
+
+	switch conf.Auth {
+	case "", "signature":
+		client.Auth = mixpanel.SignatureAuth{Key: conf.Key, Secret: conf.Secret}
+	case "projectsecret":
+		client.Auth = mixpanel.ProjectSecretAuth{Secret: conf.Secret}
+	case "serviceaccount":
+		if conf.Username == "" || conf.ProjectID == "" {
+			return nil, fmt.Errorf("`auth = serviceaccount` requires `username` and `projectid`")
+		}
+
+		client.Auth = mixpanel.ServiceAccountAuth{
+			Username:  conf.Username,
+			Secret:    conf.Secret,
+			ProjectID: conf.ProjectID,
+		}
+	default:
+		return nil, fmt.Errorf("unknown auth type: %s", conf.Auth)
+	}
+
+	if conf.Region != "" {
+		if err := client.SetRegion(conf.Region); err != nil {
+			return nil, err
+		}
+	}
+
+	if conf.BaseURL != "" {
+		client.BaseURL = conf.BaseURL
+	}
+
+	if conf.EngageURL != "" {
+		client.EngageURL = conf.EngageURL
+	}
+
+	if conf.CohortsURL != "" {
+		client.CohortsURL = conf.CohortsURL
+	}
+
+	if conf.AppURL != "" {
+		client.AppURL = conf.AppURL
+	}
+
+	if conf.Transform != "" {
+		mode, err := mixpanel.ParseTransformMode(conf.Transform)
+		if err != nil {
+			return nil, err
+		}
+
+		if mode != mixpanel.FlattenRecords && (cfg.CSV.State || cfg.Columns.State) {
+			return nil, fmt.Errorf("`transform = %s` is only supported by the JSON export", conf.Transform)
+		}
+
+		client.Transform = mode
+	}
+
+	if conf.Collisions != "" {
+		policy, err := mixpanel.ParseCollisionPolicy(conf.Collisions)
+		if err != nil {
+			return nil, err
+		}
+
+		client.Collisions = policy
+	}
+
+	if conf.IDs != "" {
+		ids, err := mixpanel.ParseIDStrategy(conf.IDs)
+		if err != nil {
+			return nil, err
+		}
+
+		client.IDs = ids
+	}
+
+	if conf.Timezone != "" {
+		loc, err := time.LoadLocation(conf.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("unknown timezone: %s", conf.Timezone)
+		}
+
+		client.Location = loc
+	}
+
+	if conf.TimestampFormat != "" {
+		format, err := mixpanel.ParseTimestampFormat(conf.TimestampFormat)
+		if err != nil {
+			return nil, err
+		}
+
+		client.TimestampFormat = format
+	}
+
+	client.LocalTimestamps = conf.LocalTimestamp
+	client.Client = httpClient
+	client.Limiter = limiter
+	client.MaxWindow = conf.Window
+	client.Logger = log.New(os.Stderr, "", log.LstdFlags)
+	client.RecordDir = recordDir
+	client.ReplayDir = replayDir
+
+	if conf.Retries != nil {
+		client.Retry.Retries = *conf.Retries
+	}
+
+	if conf.RetryWait > 0 {
+		client.Retry.MinWait = time.Duration(conf.RetryWait)
+	}
+
+	if conf.RetryMaxWait > 0 {
+		client.Retry.MaxWait = time.Duration(conf.RetryMaxWait)
+	}
+
+	client.Rejects.MaxRejects = conf.MaxRejects
+	client.Rejects.MaxRatio = conf.MaxRejectRatio
+
+	return client, nil
+}
+
 // createExportFile abstracts the handling of configuration variables common to
 // `csv`, `json`, and `columns` into a single function.
 //
+// The file is named PRODUCT[-KIND][-EVENT]-DATE.EXT, where `kind` is empty for
+// a product's events (see exportStream), and `event` is only given for
+// per-event files.
+//
 // The returned tuple contains:
 //   - A generic io.Writer which will be passed off to the export function.
 //   - A function taking no arguments which should be called after the export
 //     function finishes (using defer) to do any necessary cleanup, depending
 //     on the specified configuration options.
-func createExportFile(export exportConfig, conf fileExportConfig, event, ext string) (io.Writer, func()) {
+func createExportFile(export exportConfig, conf fileExportConfig, kind, event, ext string) (io.Writer, func()) {
 	if conf.Gzip {
 		ext += ".gz"
 	}
@@ -234,6 +719,10 @@ func createExportFile(export exportConfig, conf fileExportConfig, event, ext str
 
 	name := path.Join(conf.Directory, export.Product)
 
+	if kind != "" {
+		name += fmt.Sprintf("-%s", kind)
+	}
+
 	if event != "" {
 		name += fmt.Sprintf("-%s", event)
 	}
@@ -269,10 +758,10 @@ func createExportFile(export exportConfig, conf fileExportConfig, event, ext str
 			os.Remove(name)
 		}
 
-		// Make sure bad files get deleted if the export for this
-		// product failed.
+		// Make sure bad files get deleted if the export of this kind
+		// of records failed. Other kinds are unaffected.
 		if conf.RemoveFailed {
-			if _, err := failedExports[export.Product]; err {
+			if hasFailed(exportName(export.Product, kind)) {
 				os.Remove(name)
 			}
 		}
@@ -283,11 +772,158 @@ func createExportFile(export exportConfig, conf fileExportConfig, event, ext str
 
 // exportProduct is called once for each individual mixpanel product to be
 // exported. It starts each export function in its own goroutine and will block
-// until all events have been processed.
-func exportProduct(export exportConfig, wg *sync.WaitGroup) {
+// until all events have been processed or `ctx` is cancelled.
+func exportProduct(ctx context.Context, export exportConfig, client *mixpanel.Mixpanel, wg *sync.WaitGroup) {
+	opts := download.Options{
+		Window:      export.Conf.Window,
+		Concurrency: export.Conf.Concurrency,
+		Ordered:     export.Conf.Ordered,
+	}
+
+	exportRange := func(ctx context.Context, start, end time.Time, output chan<- mixpanel.EventData) (int, error) {
+		return client.ExportRange(ctx, start, end, output, &export.Options)
+	}
+
+	fetch := func(ctx context.Context, eventData chan<- mixpanel.EventData) (int, error) {
+		return download.Range(ctx, export.Start, export.End, opts, exportRange, eventData)
+	}
+
+	defer quarantine(export, client)()
+
+	exportStream(ctx, export, client, "", fetch, wg)
+}
+
+// quarantine makes the client write malformed records to a
+// PRODUCT-DATE.rejects file, if `rejects` is configured for the product. The
+// file is only created once the first record is rejected. The returned
+// function closes it.
+func quarantine(export exportConfig, client *mixpanel.Mixpanel) func() {
+	if export.Conf.Rejects == "" {
+		return func() {}
+	}
+
+	file := &lazyWriter{create: func() (io.Writer, func()) {
+		return createExportFile(export, fileExportConfig{Directory: export.Conf.Rejects}, "", "", "rejects")
+	}}
+
+	client.Rejects.Output = file
+
+	return func() {
+		if file.cleanup != nil {
+			file.cleanup()
+		}
+	}
+}
+
+// lazyWriter defers creating the underlying writer until the first write.
+type lazyWriter struct {
+	create  func() (io.Writer, func())
+	writer  io.Writer
+	cleanup func()
+}
+
+func (l *lazyWriter) Write(p []byte) (int, error) {
+	if l.writer == nil {
+		l.writer, l.cleanup = l.create()
+	}
+
+	return l.writer.Write(p)
+}
+
+// exportProfiles is called for each product with `profiles` enabled, to export
+// its people profiles alongside the events.
+func exportProfiles(ctx context.Context, export exportConfig, client *mixpanel.Mixpanel, wg *sync.WaitGroup) {
+	download := func(ctx context.Context, eventData chan<- mixpanel.EventData) (int, error) {
+		return client.ExportProfiles(ctx, eventData, nil)
+	}
+
+	exportStream(ctx, export, client, "profiles", download, wg)
+}
+
+// cohortColumns are the columns of the cohort membership export.
+var cohortColumns = []string{"cohort_id", "cohort_name", "distinct_id"}
+
+// exportCohorts writes the members of each of the product's cohorts to a
+// PRODUCT-cohorts-DATE file, one `cohort_id,cohort_name,distinct_id` row per
+// member.
+func exportCohorts(ctx context.Context, export exportConfig, client *mixpanel.Mixpanel, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	name := exportName(export.Product, "cohorts")
+
+	writer, cleanup := createExportFile(export, cfg.Cohorts, "cohorts", "", "csv")
+	defer cleanup()
+
+	records := make(chan mixpanel.EventData, 100)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		defs := map[string]exports.EventColumnDef{
+			mixpanel.CohortMemberEvent: exports.NewEventColumnDef(writer, cohortColumns),
+		}
+
+		exports.CSVColumnStreamer(defs, records)
+	}()
+
+	cohorts, err := client.ListCohorts(ctx)
+
+	total := 0
+	if err == nil {
+		total, err = client.ExportCohortMembers(ctx, cohorts, records)
+	}
+
+	close(records)
+	<-done
+
+	if ctx.Err() != nil {
+		log.Printf("%s: export cancelled.", name)
+		markFailed(name)
+	} else if err != nil {
+		logFailure(name, err)
+		markFailed(name)
+	} else {
+		log.Printf("%s: %d records in %d cohorts.", name, total, len(cohorts))
+	}
+}
+
+// logFailure logs why the named export failed, along with what might be done
+// about it.
+func logFailure(name string, err error) {
+	log.Printf("%s: export failed: %v", name, err)
+
+	switch err := err.(type) {
+	case *mixpanel.APIError:
+		switch err.Err {
+		case mixpanel.ErrUnauthorized:
+			log.Printf("%s: check the credentials (`key`, `secret`, `auth`) and `region` of the product.", name)
+		case mixpanel.ErrRateLimited:
+			log.Printf("%s: lower `maxconcurrent` or `maxperhour` in [mixpanel], or raise `retries`.", name)
+		}
+	case *mixpanel.NetworkError:
+		if err.Timeout() {
+			log.Printf("%s: consider raising `connecttimeout` or `idletimeout` in [http].", name)
+		}
+	case *mixpanel.ParseError:
+		if err.Data != nil {
+			log.Printf("%s: offending record: %.200s", name, err.Data)
+		}
+	}
+}
+
+// exportStream writes the records produced by `download` to each of the
+// configured exporters, blocking until all of them have been processed or
+// `ctx` is cancelled.
+//
+// `kind` is empty for a product's events. Other kinds of records (e.g.
+// "profiles") are written to files of their own, named PRODUCT-KIND-DATE, and
+// only the columns defined for mixpanel.ProfileEvent apply to them.
+func exportStream(ctx context.Context, export exportConfig, client *mixpanel.Mixpanel, kind string, download func(context.Context, chan<- mixpanel.EventData) (int, error), wg *sync.WaitGroup) {
 	defer wg.Done()
 
-	client := mixpanel.New(export.Product, export.Creds.Key, export.Creds.Secret)
+	name := exportName(export.Product, kind)
+
 	eventData := make(chan mixpanel.EventData)
 
 	// We need to mux eventData into multiple channels to ensure all export
@@ -311,7 +947,7 @@ func exportProduct(export exportConfig, wg *sync.WaitGroup) {
 		go func() {
 			defer wg.Done()
 
-			writer, cleanup := createExportFile(export, cfg.JSON, "", "json")
+			writer, cleanup := createExportFile(export, cfg.JSON, kind, "", "json")
 			defer cleanup()
 
 			exports.JSONStreamer(writer, c)
@@ -325,7 +961,7 @@ func exportProduct(export exportConfig, wg *sync.WaitGroup) {
 		go func() {
 			defer wg.Done()
 
-			writer, cleanup := createExportFile(export, cfg.CSV, "", "csv")
+			writer, cleanup := createExportFile(export, cfg.CSV, kind, "", "csv")
 			defer cleanup()
 
 			exports.CSVStreamer(writer, c)
@@ -349,9 +985,18 @@ func exportProduct(export exportConfig, wg *sync.WaitGroup) {
 
 		fp.Close()
 
+		// Profiles only have a single kind of record, written to a
+		// file named after the kind.
+		prodCols := make(map[string][]string)
+		for event, cols := range columns[export.Product] {
+			if (event == mixpanel.ProfileEvent) == (kind == "profiles") {
+				prodCols[event] = cols
+			}
+		}
+
 		// Not much sense in consuming the stream if we have no events
 		// to actually capture.
-		if prodCols, ok := columns[export.Product]; ok {
+		if len(prodCols) > 0 {
 			c := makeChan()
 
 			wg.Add(1)
@@ -361,8 +1006,15 @@ func exportProduct(export exportConfig, wg *sync.WaitGroup) {
 				defs := make(map[string]exports.EventColumnDef)
 
 				for event, cols := range prodCols {
+					// Other kinds are written to a single
+					// file named after the kind alone.
+					file := event
+					if kind != "" {
+						file = ""
+					}
+
 					writer, cleanup := createExportFile(
-						export, cfg.Columns.fileExportConfig, event, "csv")
+						export, cfg.Columns.fileExportConfig, kind, file, "csv")
 
 					defs[event] = exports.NewEventColumnDef(writer, cols)
 
@@ -377,49 +1029,87 @@ func exportProduct(export exportConfig, wg *sync.WaitGroup) {
 	go func() {
 		defer close(eventData)
 
-		// Keep track of the total number of lines that have been
-		// processed.
-		total := 0
-
-		// We want it to be start-end inclusive, so add one day to end
-		// date.
-		end := export.End.AddDate(0, 0, 1)
-
-		for date := export.Start; date.Before(end); date = date.AddDate(0, 0, 1) {
-			num, err := client.ExportDate(date, eventData, nil)
-
-			dateStr := date.Format("2006-01-02")
-
-			// Bail out early if one of the exports for this product
-			// fails.
-			//
-			// TODO: Should we keep going and not report error
-			//       until the end? Maybe make that configurable.
-			if err != nil {
-				log.Printf("%s: %s: export failed: %v", dateStr, export.Product, err)
-				failedExports[export.Product] = true
-				return
-			} else if num == 0 {
-				log.Printf("%s: %s: no records.", dateStr, export.Product)
-			}
-
-			total += num
+		// Bail out early if one of the exports for this product
+		// fails. The client logs its progress along the way.
+		//
+		// TODO: Should we keep going and not report error
+		//       until the end? Maybe make that configurable.
+		total, err := download(ctx, eventData)
+
+		if ctx.Err() != nil {
+			log.Printf("%s: export cancelled.", name)
+			markFailed(name)
+			return
+		} else if err != nil {
+			logFailure(name, err)
+			markFailed(name)
+			return
 		}
 
-		log.Printf("%s: %d records.", export.Product, total)
+		log.Printf("%s: %d records.", name, total)
 	}()
 
+	records := (<-chan mixpanel.EventData)(eventData)
+
+	var (
+		dropped  int
+		dedupErr error
+	)
+
+	// Run the records through a deduplication stage before they reach
+	// any of the exporters. Profiles are unique already.
+	dedupe := export.Conf.Dedup && kind == ""
+
+	if dedupe {
+		keys := export.Conf.DedupKey
+		if len(keys) == 0 {
+			keys = []string{"$insert_id"}
+		}
+
+		set := dedup.NewSet("", export.Conf.DedupMaxKeys)
+		defer set.Close()
+
+		deduped := make(chan mixpanel.EventData, 100)
+		records = deduped
+
+		go func() {
+			dropped, dedupErr = dedup.Filter(set, keys, eventData, deduped)
+			close(deduped)
+		}()
+	}
+
 	// Multiplex each received event to each of the active export funcs.
-	for data := range eventData {
+	for data := range records {
 		for _, ch := range chans {
 			ch <- data
 		}
 	}
 
+	// Like the download, a failed deduplication has to be marked before
+	// the exporters finish, so that their cleanup sees it.
+	if dedupErr != nil {
+		markFailed(name)
+	}
+
 	// Closing all the channels will signal the streaming export functions
 	// that they've reached the end of the stream and should terminate as
 	// soon as the channel is drained.
 	for _, ch := range chans {
 		close(ch)
 	}
+
+	if dedupErr != nil {
+		log.Printf("%s: deduplication failed: %v", name, dedupErr)
+	} else if dedupe {
+		log.Printf("%s: dropped %d duplicate records.", name, dropped)
+	}
+
+	if client.Rejects.Output != nil {
+		log.Printf("%s: rejected %d malformed records.", name, client.RejectCount())
+	}
+
+	for _, c := range client.CollisionCounts() {
+		log.Printf("%s: %s: %d records with a property colliding with %q.",
+			name, c.Event, c.Count, c.Key)
+	}
 }
@@ -0,0 +1,103 @@
+package mixpanel
+
+import (
+	"crypto/md5"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Authenticator attaches credentials to an API request before it's sent.
+//
+// Mixpanel supports several authentication schemes, see SignatureAuth,
+// ProjectSecretAuth and ServiceAccountAuth.
+type Authenticator interface {
+	Authenticate(req *http.Request)
+}
+
+// SignatureAuth implements Mixpanel's legacy authentication scheme: the API
+// key is passed as a parameter, and the request is signed with an MD5 digest
+// of its arguments and the API secret.
+//
+// Mixpanel has deprecated this scheme, prefer ServiceAccountAuth.
+type SignatureAuth struct {
+	Key, Secret string
+}
+
+// Authenticate implements Authenticator.
+func (a SignatureAuth) Authenticate(req *http.Request) {
+	args := req.URL.Query()
+
+	args.Set("api_key", a.Key)
+	args.Set("expire", fmt.Sprintf("%d", time.Now().Unix()+10000))
+
+	addSignature(&args, a.Secret)
+
+	req.URL.RawQuery = args.Encode()
+}
+
+// Add the cryptographic signature that Mixpanel API requests require.
+//
+// Algorithm:
+// - join key=value pairs
+// - sort the pairs alphabetically
+// - appending a secret
+// - take MD5 hex digest.
+func addSignature(args *url.Values, secret string) {
+	hash := md5.New()
+
+	var params []string
+	for k, vs := range *args {
+		for _, v := range vs {
+			params = append(params, fmt.Sprintf("%s=%s", k, v))
+		}
+	}
+
+	sort.StringSlice(params).Sort()
+
+	io.WriteString(hash, strings.Join(params, "")+secret)
+	args.Set("sig", fmt.Sprintf("%x", hash.Sum(nil)))
+}
+
+// ProjectSecretAuth authenticates using the project's API secret as the
+// username for HTTP Basic authentication.
+type ProjectSecretAuth struct {
+	Secret string
+}
+
+// Authenticate implements Authenticator.
+func (a ProjectSecretAuth) Authenticate(req *http.Request) {
+	req.SetBasicAuth(a.Secret, "")
+}
+
+// ServiceAccountAuth authenticates as a Mixpanel service account using HTTP
+// Basic authentication. Since a service account can have access to several
+// projects, the ID of the project being queried has to be given as well.
+type ServiceAccountAuth struct {
+	Username, Secret string
+	ProjectID        string
+}
+
+// Authenticate implements Authenticator.
+func (a ServiceAccountAuth) Authenticate(req *http.Request) {
+	req.SetBasicAuth(a.Username, a.Secret)
+
+	args := req.URL.Query()
+	args.Set("project_id", a.ProjectID)
+
+	req.URL.RawQuery = args.Encode()
+}
+
+// authenticator returns the Authenticator used for API requests, defaulting
+// to signing requests with the client's key and secret.
+func (m *Mixpanel) authenticator() Authenticator {
+	if m.Auth != nil {
+		return m.Auth
+	}
+
+	return SignatureAuth{m.Key, m.Secret}
+}
@@ -0,0 +1,72 @@
+package mixpanel
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestAddSignature(t *testing.T) {
+	args := url.Values{}
+	args.Set("b", "2")
+	args.Set("a", "1")
+
+	addSignature(&args, "secret")
+
+	// md5("a=1b=2secret")
+	if sig := args.Get("sig"); sig != "d37cfe88ec8ff020e497f5197bf3ba1c" {
+		t.Errorf("bad signature: %s", sig)
+	}
+}
+
+func TestSignatureAuth(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://example.com/?from_date=2014-01-01", nil)
+
+	SignatureAuth{"key", "secret"}.Authenticate(req)
+
+	args := req.URL.Query()
+
+	if args.Get("api_key") != "key" {
+		t.Errorf("expected api_key=key, got %q", args.Get("api_key"))
+	}
+
+	if args.Get("from_date") != "2014-01-01" {
+		t.Error("original arguments were dropped")
+	}
+
+	sig := args.Get("sig")
+	args.Del("sig")
+	addSignature(&args, "secret")
+
+	if sig == "" || args.Get("sig") != sig {
+		t.Errorf("signature doesn't match arguments: %s", sig)
+	}
+}
+
+func TestServiceAccountAuth(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://example.com/?from_date=2014-01-01", nil)
+
+	ServiceAccountAuth{"user", "pass", "1234"}.Authenticate(req)
+
+	if user, pass, ok := req.BasicAuth(); !ok || user != "user" || pass != "pass" {
+		t.Errorf("bad basic auth: %s:%s", user, pass)
+	}
+
+	if id := req.URL.Query().Get("project_id"); id != "1234" {
+		t.Errorf("expected project_id=1234, got %q", id)
+	}
+
+	if req.URL.Query().Get("sig") != "" {
+		t.Error("service account requests shouldn't be signed")
+	}
+}
+
+func TestProjectSecretAuth(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://example.com/", nil)
+
+	ProjectSecretAuth{"secret"}.Authenticate(req)
+
+	if user, pass, ok := req.BasicAuth(); !ok || user != "secret" || pass != "" {
+		t.Errorf("bad basic auth: %s:%s", user, pass)
+	}
+}
@@ -0,0 +1,120 @@
+package mixpanel
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// HTTPOptions describes the transport level settings used by NewHTTPClient.
+//
+// - `ConnectTimeout` limits how long establishing a connection (including
+//   the TLS handshake) may take.
+// - `IdleTimeout` limits how long a connection may go without receiving any
+//   data. This also covers waiting for the response headers, which Mixpanel
+//   can take a while to send for large exports, so be generous.
+// - `Proxy` is the URL of an HTTP(S) proxy to send requests through. If
+//   empty, the standard `HTTPS_PROXY` environment variables are honored.
+// - `CAFile` is the path to a PEM encoded bundle of additional certificate
+//   authorities to trust, on top of the system pool.
+//
+// A zero timeout means no timeout.
+type HTTPOptions struct {
+	ConnectTimeout time.Duration
+	IdleTimeout    time.Duration
+	Proxy          string
+	CAFile         string
+}
+
+// DefaultHTTPOptions are reasonable settings to keep a stalled download from
+// hanging forever.
+var DefaultHTTPOptions = HTTPOptions{
+	ConnectTimeout: 30 * time.Second,
+	IdleTimeout:    5 * time.Minute,
+}
+
+// NewHTTPClient creates an http.Client suitable for use as `Mixpanel.Client`
+// from the given options.
+func NewHTTPClient(opts HTTPOptions) (*http.Client, error) {
+	dialer := &net.Dialer{
+		Timeout:   opts.ConnectTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+
+	transport := &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		TLSHandshakeTimeout: opts.ConnectTimeout,
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := dialer.DialContext(ctx, network, addr)
+			if err != nil || opts.IdleTimeout <= 0 {
+				return conn, err
+			}
+
+			return &idleTimeoutConn{conn, opts.IdleTimeout}, nil
+		},
+	}
+
+	if opts.Proxy != "" {
+		proxy, err := url.Parse(opts.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("bad proxy URL: %s", err)
+		}
+
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+
+	if opts.CAFile != "" {
+		pem, err := ioutil.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read CA bundle: %s", err)
+		}
+
+		// Fall back to an empty pool on platforms where the system
+		// pool isn't available.
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", opts.CAFile)
+		}
+
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	return &http.Client{Transport: transport}, nil
+}
+
+// idleTimeoutConn wraps a net.Conn, pushing the read and write deadlines
+// forward on every operation so that only a connection that's gone quiet for
+// `timeout` is killed, rather than one that's simply slow overall.
+type idleTimeoutConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *idleTimeoutConn) Read(b []byte) (int, error) {
+	c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+	return c.Conn.Read(b)
+}
+
+func (c *idleTimeoutConn) Write(b []byte) (int, error) {
+	c.Conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	return c.Conn.Write(b)
+}
+
+// httpClient returns the http.Client used to make API requests.
+func (m *Mixpanel) httpClient() *http.Client {
+	if m.Client != nil {
+		return m.Client
+	}
+
+	return http.DefaultClient
+}
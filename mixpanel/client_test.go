@@ -0,0 +1,83 @@
+package mixpanel
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestIdleTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `{"event": "a", "properties": {}}`)
+		w.(http.Flusher).Flush()
+
+		// Stall the rest of the body.
+		time.Sleep(500 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	client, err := NewHTTPClient(HTTPOptions{IdleTimeout: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("expected headers to arrive in time, got %v", err)
+	}
+	defer resp.Body.Close()
+
+	if _, err := ioutil.ReadAll(resp.Body); err == nil {
+		t.Error("expected stalled body to time out")
+	}
+}
+
+func TestProxy(t *testing.T) {
+	var requested string
+
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = r.URL.String()
+	}))
+	defer proxy.Close()
+
+	mix := NewWithURL("product", "key", "secret", "http://mixpanel.invalid/export")
+	mix.Retry.Retries = 0
+
+	client, err := NewHTTPClient(HTTPOptions{Proxy: proxy.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mix.Client = client
+
+	if _, err := mix.ExportDate(time.Now(), make(chan EventData), nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if u, err := url.Parse(requested); err != nil || u.Host != "mixpanel.invalid" {
+		t.Errorf("expected request to go through proxy, saw %q", requested)
+	}
+}
+
+func TestBadCAFile(t *testing.T) {
+	if _, err := NewHTTPClient(HTTPOptions{CAFile: "/does/not/exist"}); err == nil {
+		t.Error("expected error for missing CA file")
+	}
+
+	fp, err := ioutil.TempFile("", "mixport-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(fp.Name())
+
+	fp.WriteString("not a certificate")
+	fp.Close()
+
+	if _, err := NewHTTPClient(HTTPOptions{CAFile: fp.Name()}); err == nil {
+		t.Error("expected error for CA file without certificates")
+	}
+}
@@ -0,0 +1,118 @@
+package mixpanel
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+)
+
+// The official cohort listing API URL
+const MixpanelCohortsURL = "https://mixpanel.com/api/2.0/cohorts/list"
+
+// RegionCohortsURLs maps each of Mixpanel's data residency regions to its
+// cohort listing API endpoint, see RegionBaseURLs.
+var RegionCohortsURLs = map[string]string{
+	"us": MixpanelCohortsURL,
+	"eu": "https://eu.mixpanel.com/api/2.0/cohorts/list",
+	"in": "https://in.mixpanel.com/api/2.0/cohorts/list",
+}
+
+// Value of the "event" key of cohort membership records, see
+// ExportCohortMembers.
+const CohortMemberEvent = "$cohort_member"
+
+// Cohort describes one of the project's saved cohorts.
+type Cohort struct {
+	ID          int64  `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Count       int    `json:"count"`
+	Created     string `json:"created"`
+	IsVisible   int    `json:"is_visible"`
+}
+
+// ListCohorts returns all of the project's saved cohorts.
+func (m *Mixpanel) ListCohorts(ctx context.Context) ([]Cohort, error) {
+	resp, err := m.get(ctx, m.CohortsURL, url.Values{})
+	if err != nil {
+		return nil, m.failed("listing cohorts", err)
+	}
+
+	defer resp.Body.Close()
+
+	// Errors come back as an object rather than a list.
+	var body json.RawMessage
+
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	} else if err != nil {
+		return nil, &ParseError{Product: m.Product, Err: err}
+	}
+
+	accept(resp.Body)
+
+	var cohorts []Cohort
+
+	if err := json.Unmarshal(body, &cohorts); err != nil {
+		var apiErr struct{ Error string }
+
+		if json.Unmarshal(body, &apiErr) == nil && apiErr.Error != "" {
+			return nil, &APIError{Product: m.Product, StatusCode: resp.StatusCode, Body: apiErr.Error}
+		}
+
+		return nil, &ParseError{Product: m.Product, Err: err}
+	}
+
+	return cohorts, nil
+}
+
+// CohortArgs returns the Engage API arguments restricting a query to the
+// members of the cohort, to be passed to ExportProfiles.
+func CohortArgs(cohort Cohort) *url.Values {
+	return &url.Values{
+		"filter_by_cohort": {fmt.Sprintf(`{"id": %d}`, cohort.ID)},
+	}
+}
+
+// ExportCohortMembers streams a record for every member of each of the given
+// cohorts over `output`, with the keys "cohort_id", "cohort_name",
+// "distinct_id", "product", and "event" (set to CohortMemberEvent).
+//
+// Returns the number of records that have been processed and possibly an
+// error.
+func (m *Mixpanel) ExportCohortMembers(ctx context.Context, cohorts []Cohort, output chan<- EventData) (int, error) {
+	total := 0
+
+	for _, cohort := range cohorts {
+		num := 0
+
+		err := m.eachProfile(ctx, *CohortArgs(cohort), func(distinctID interface{}, _ map[string]interface{}) error {
+			record := EventData{
+				"cohort_id":   cohort.ID,
+				"cohort_name": cohort.Name,
+				"distinct_id": distinctID,
+				"product":     m.Product,
+				"event":       CohortMemberEvent,
+			}
+
+			select {
+			case output <- record:
+				num++
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+
+		total += num
+
+		if err != nil {
+			return total, err
+		}
+
+		m.logf("%s: cohort %q: %d members.", m.Product, cohort.Name, num)
+	}
+
+	return total, nil
+}
@@ -0,0 +1,85 @@
+package mixpanel
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func cohortServer() *httptest.Server {
+	members := map[string]string{
+		`{"id": 1}`: `{"$distinct_id": "a"}, {"$distinct_id": "b"}`,
+		`{"id": 2}`: `{"$distinct_id": "c"}`,
+	}
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/cohorts/list", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[{"id": 1, "name": "One", "count": 2}, {"id": 2, "name": "Two", "count": 1}]`)
+	})
+
+	mux.HandleFunc("/engage", func(w http.ResponseWriter, r *http.Request) {
+		results := members[r.URL.Query().Get("filter_by_cohort")]
+		fmt.Fprintf(w, `{"page": 0, "page_size": 1000, "session_id": "s", "results": [%s]}`, results)
+	})
+
+	return httptest.NewServer(mux)
+}
+
+func TestExportCohortMembers(t *testing.T) {
+	server := cohortServer()
+	defer server.Close()
+
+	mix := New("product", "key", "secret")
+	mix.CohortsURL = server.URL + "/cohorts/list"
+	mix.EngageURL = server.URL + "/engage"
+
+	cohorts, err := mix.ListCohorts(context.Background())
+	if err != nil {
+		t.Fatalf("raised error: %v", err)
+	} else if len(cohorts) != 2 || cohorts[1].ID != 2 || cohorts[1].Name != "Two" {
+		t.Fatalf("bad cohorts: %v", cohorts)
+	}
+
+	output := make(chan EventData, 10)
+
+	if num, err := mix.ExportCohortMembers(context.Background(), cohorts, output); err != nil {
+		t.Fatalf("raised error: %v", err)
+	} else if num != 3 {
+		t.Errorf("expected 3 members, got %d", num)
+	}
+
+	close(output)
+
+	var rows []string
+	for record := range output {
+		if record["event"] != CohortMemberEvent {
+			t.Errorf("bad event: %v", record["event"])
+		}
+
+		rows = append(rows, fmt.Sprintf("%v,%v,%v", record["cohort_id"], record["cohort_name"], record["distinct_id"]))
+	}
+
+	expected := []string{"1,One,a", "1,One,b", "2,Two,c"}
+	if fmt.Sprint(rows) != fmt.Sprint(expected) {
+		t.Errorf("expected %v, got %v", expected, rows)
+	}
+}
+
+func TestListCohortsApiError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"error": "bad credentials"}`)
+	}))
+	defer server.Close()
+
+	mix := New("product", "key", "secret")
+	mix.CohortsURL = server.URL
+
+	_, err := mix.ListCohorts(context.Background())
+	if apiErr, ok := err.(*APIError); !ok || apiErr.Err != ErrUnauthorized || apiErr.Body != "bad credentials" {
+		t.Errorf("expected API error, got %v", err)
+	}
+}
@@ -0,0 +1,146 @@
+package mixpanel
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Prefix given to properties moved out of the way of reserved keys, see
+// PrefixCollisions.
+const OriginalPrefix = "original_"
+
+// CollisionPolicy selects what happens when flattening a record (see
+// FlattenRecords) would overwrite one of its properties with a reserved key:
+// "product", "event", EventIDKey, TimestampKey or LocalTimestampKey.
+type CollisionPolicy int
+
+const (
+	// OverwriteCollisions replaces the property with the reserved key's
+	// value.
+	OverwriteCollisions CollisionPolicy = iota
+
+	// PrefixCollisions moves the property to a key prefixed with
+	// OriginalPrefix (repeatedly, if that's taken as well).
+	PrefixCollisions
+
+	// FailCollisions aborts the export with an error.
+	FailCollisions
+
+	// RenameCollisions keeps the property as is, and stores the reserved
+	// key's value under the key prefixed with MetadataKey and an
+	// underscore instead, e.g. "_mixport_product".
+	//
+	// The exporters rely on "event" and EventIDKey to tell records apart,
+	// so properties colliding with those are moved out of the way like
+	// with PrefixCollisions.
+	RenameCollisions
+)
+
+// ParseCollisionPolicy converts the name of a CollisionPolicy ("overwrite",
+// "prefix", "fail" or "rename") into its value.
+func ParseCollisionPolicy(name string) (CollisionPolicy, error) {
+	switch name {
+	case "overwrite":
+		return OverwriteCollisions, nil
+	case "prefix":
+		return PrefixCollisions, nil
+	case "fail":
+		return FailCollisions, nil
+	case "rename":
+		return RenameCollisions, nil
+	}
+
+	return OverwriteCollisions, fmt.Errorf("unknown collision policy: %s", name)
+}
+
+// Collision counts the properties of an event that collided with a reserved
+// key.
+type Collision struct {
+	Event string
+	Key   string
+	Count int
+}
+
+// set stores `value` under the reserved `key` of a flattened record,
+// resolving collisions with the event's own properties according to the
+// client's CollisionPolicy.
+func (m *Mixpanel) set(record EventData, event, key string, value interface{}) error {
+	if _, ok := record[key]; !ok {
+		record[key] = value
+		return nil
+	}
+
+	if m.Collisions == FailCollisions {
+		return fmt.Errorf("%s: property %q of event %q collides with a reserved key",
+			m.Product, key, event)
+	}
+
+	m.countCollision(event, key)
+
+	policy := m.Collisions
+	if policy == RenameCollisions && (key == "event" || key == EventIDKey) {
+		policy = PrefixCollisions
+	}
+
+	switch policy {
+	case PrefixCollisions:
+		record[freeKey(record, OriginalPrefix+key, OriginalPrefix)] = record[key]
+		record[key] = value
+	case RenameCollisions:
+		record[freeKey(record, MetadataKey+"_"+key, "_")] = value
+	default:
+		record[key] = value
+	}
+
+	return nil
+}
+
+// freeKey prepends `prefix` to `key` until it's not present in the record.
+func freeKey(record EventData, key, prefix string) string {
+	for {
+		if _, ok := record[key]; !ok {
+			return key
+		}
+
+		key = prefix + key
+	}
+}
+
+func (m *Mixpanel) countCollision(event, key string) {
+	m.collisionsMu.Lock()
+	defer m.collisionsMu.Unlock()
+
+	if m.collisions == nil {
+		m.collisions = make(map[[2]string]int)
+	}
+
+	m.collisions[[2]string{event, key}]++
+}
+
+// CollisionCounts returns how many times each event's properties collided
+// with each reserved key so far, sorted by event and key.
+func (m *Mixpanel) CollisionCounts() []Collision {
+	m.collisionsMu.Lock()
+	defer m.collisionsMu.Unlock()
+
+	counts := make([]Collision, 0, len(m.collisions))
+	for k, n := range m.collisions {
+		counts = append(counts, Collision{Event: k[0], Key: k[1], Count: n})
+	}
+
+	sort.Sort(byEventAndKey(counts))
+
+	return counts
+}
+
+// byEventAndKey implements sort.Interface.
+type byEventAndKey []Collision
+
+func (c byEventAndKey) Len() int      { return len(c) }
+func (c byEventAndKey) Swap(i, j int) { c[i], c[j] = c[j], c[i] }
+func (c byEventAndKey) Less(i, j int) bool {
+	if c[i].Event != c[j].Event {
+		return c[i].Event < c[j].Event
+	}
+	return c[i].Key < c[j].Key
+}
@@ -0,0 +1,82 @@
+package mixpanel
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const collidingLine = `{"event": "a", "properties": {"event": "x", "product": "y", "original_product": "z"}}`
+
+func TestOverwriteCollisions(t *testing.T) {
+	mix := New("product", "", "")
+	event := transformOne(t, mix, collidingLine)
+
+	if event["event"] != "a" || event["product"] != "product" {
+		t.Errorf("expected reserved keys to win, got %v", event)
+	}
+
+	expected := []Collision{{"a", "event", 1}, {"a", "product", 1}}
+	if counts := mix.CollisionCounts(); !reflect.DeepEqual(counts, expected) {
+		t.Errorf("expected %v, got %v", expected, counts)
+	}
+}
+
+func TestPrefixCollisions(t *testing.T) {
+	mix := New("product", "", "")
+	mix.Collisions = PrefixCollisions
+
+	event := transformOne(t, mix, collidingLine)
+
+	expected := map[string]interface{}{
+		"event":                     "a",
+		"product":                   "product",
+		"original_event":            "x",
+		"original_product":          "z",
+		"original_original_product": "y",
+	}
+
+	for key, value := range expected {
+		if event[key] != value {
+			t.Errorf("%s: expected %v, got %v", key, value, event[key])
+		}
+	}
+}
+
+func TestRenameCollisions(t *testing.T) {
+	mix := New("product", "", "")
+	mix.Collisions = RenameCollisions
+
+	event := transformOne(t, mix, collidingLine)
+
+	// The event name can't be renamed, the exporters depend on it.
+	expected := map[string]interface{}{
+		"event":            "a",
+		"original_event":   "x",
+		"product":          "y",
+		"_mixport_product": "product",
+	}
+
+	for key, value := range expected {
+		if event[key] != value {
+			t.Errorf("%s: expected %v, got %v", key, value, event[key])
+		}
+	}
+}
+
+func TestFailCollisions(t *testing.T) {
+	mix := New("product", "", "")
+	mix.Collisions = FailCollisions
+
+	output := make(chan EventData, 1)
+
+	_, err := mix.TransformEventData(strings.NewReader(collidingLine), output)
+	if err == nil || !strings.Contains(err.Error(), "collides") {
+		t.Errorf("expected collision error, got %v", err)
+	}
+
+	// No collisions at all are fine.
+	if _, err := mix.TransformEventData(strings.NewReader(`{"event": "a"}`), output); err != nil {
+		t.Errorf("raised error: %v", err)
+	}
+}
@@ -0,0 +1,172 @@
+package mixpanel
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+// The official Engage (people profiles) API URL
+const MixpanelEngageURL = "https://mixpanel.com/api/2.0/engage"
+
+// RegionEngageURLs maps each of Mixpanel's data residency regions to its
+// Engage API endpoint, see RegionBaseURLs.
+var RegionEngageURLs = map[string]string{
+	"us": MixpanelEngageURL,
+	"eu": "https://eu.mixpanel.com/api/2.0/engage",
+	"in": "https://in.mixpanel.com/api/2.0/engage",
+}
+
+// Value of the "event" key of profile records, so that they can be told
+// apart from (and handled like) events by the exporters.
+const ProfileEvent = "$profile"
+
+// Key into the EventData map of profile records that contains the profile's
+// distinct ID.
+const DistinctIDKey = "$distinct_id"
+
+// engagePage is a single page of results from the Engage API.
+type engagePage struct {
+	Error     *string `json:"error"`
+	Page      int     `json:"page"`
+	PageSize  int     `json:"page_size"`
+	SessionID string  `json:"session_id"`
+	Results   []struct {
+		DistinctID interface{}            `json:"$distinct_id"`
+		Properties map[string]interface{} `json:"$properties"`
+	} `json:"results"`
+}
+
+// ExportProfiles downloads all of the project's people profiles, streaming
+// them over `output` as records like ExportDate does for events.
+//
+// Each profile's `$properties` are folded into the top level of the record,
+// along with DistinctIDKey, "product", and "event" (set to ProfileEvent).
+// The distinct ID doubles as the record's ID under EventIDKey. Collisions
+// with these keys are handled according to `m.Collisions`, regardless of
+// `m.Transform`.
+//
+// Profiles are requested a page at a time. The optional `moreArgs` parameter
+// can be given to add additional URL parameters to each request, e.g.
+// `where` to select a subset of the profiles.
+//
+// Returns the number of records that have been processed and possibly an
+// error.
+func (m *Mixpanel) ExportProfiles(ctx context.Context, output chan<- EventData, moreArgs *url.Values) (int, error) {
+	args := url.Values{}
+
+	if moreArgs != nil {
+		for k, vs := range *moreArgs {
+			for _, v := range vs {
+				args.Add(k, v)
+			}
+		}
+	}
+
+	total := 0
+
+	err := m.eachProfile(ctx, args, func(distinctID interface{}, properties map[string]interface{}) error {
+		record, err := m.profile(distinctID, properties)
+		if err != nil {
+			return err
+		}
+
+		select {
+		case output <- record:
+			total++
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+
+	return total, err
+}
+
+// eachProfile pages through the profiles selected by `args`, calling `f` for
+// each one of them. Stops at the first error returned by `f`.
+func (m *Mixpanel) eachProfile(ctx context.Context, args url.Values, f func(distinctID interface{}, properties map[string]interface{}) error) error {
+	for {
+		page, err := m.engagePage(ctx, args)
+		if err != nil {
+			return err
+		}
+
+		for _, result := range page.Results {
+			if err := f(result.DistinctID, result.Properties); err != nil {
+				return err
+			}
+		}
+
+		m.logf("%s: profiles page %d: %d records.", m.Product, page.Page, len(page.Results))
+
+		// A short page is the last one.
+		if len(page.Results) == 0 || len(page.Results) < page.PageSize {
+			return nil
+		}
+
+		args.Set("session_id", page.SessionID)
+		args.Set("page", strconv.Itoa(page.Page+1))
+	}
+}
+
+// engagePage requests and decodes a single page of profiles.
+func (m *Mixpanel) engagePage(ctx context.Context, args url.Values) (*engagePage, error) {
+	resp, err := m.get(ctx, m.EngageURL, args)
+	if err != nil {
+		return nil, m.failed("download", err)
+	}
+
+	defer resp.Body.Close()
+
+	decoder := json.NewDecoder(resp.Body)
+
+	// Don't default all numeric values to float
+	decoder.UseNumber()
+
+	var page engagePage
+
+	if err := decoder.Decode(&page); err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	} else if err != nil {
+		return nil, &ParseError{Product: m.Product, Err: err}
+	}
+
+	accept(resp.Body)
+
+	if page.Error != nil {
+		return nil, &APIError{Product: m.Product, StatusCode: resp.StatusCode, Body: *page.Error}
+	}
+
+	return &page, nil
+}
+
+// profile assembles the record of a single profile.
+func (m *Mixpanel) profile(distinctID interface{}, properties map[string]interface{}) (EventData, error) {
+	record := EventData(properties)
+	if record == nil {
+		record = make(EventData)
+	}
+
+	id := fmt.Sprint(distinctID)
+
+	reserved := []struct {
+		key   string
+		value interface{}
+	}{
+		{EventIDKey, id},
+		{DistinctIDKey, distinctID},
+		{"product", m.Product},
+		{"event", ProfileEvent},
+	}
+
+	for _, r := range reserved {
+		if err := m.set(record, ProfileEvent, r.key, r.value); err != nil {
+			return nil, err
+		}
+	}
+
+	return record, nil
+}
@@ -0,0 +1,84 @@
+package mixpanel
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// engageServer serves `total` profiles, `pageSize` at a time.
+func engageServer(t *testing.T, total, pageSize int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		args := r.URL.Query()
+		page, _ := strconv.Atoi(args.Get("page"))
+
+		if page > 0 && args.Get("session_id") != "session" {
+			t.Errorf("page %d requested without session_id", page)
+		}
+
+		var results []string
+		for i := page * pageSize; i < total && i < (page+1)*pageSize; i++ {
+			results = append(results, fmt.Sprintf(
+				`{"$distinct_id": "u%d", "$properties": {"$email": "u%d@example.com", "n": %d}}`, i, i, i))
+		}
+
+		fmt.Fprintf(w, `{"page": %d, "page_size": %d, "session_id": "session", "status": "ok", "total": %d, "results": [%s]}`,
+			page, pageSize, total, strings.Join(results, ","))
+	}))
+}
+
+func TestExportProfiles(t *testing.T) {
+	for _, total := range []int{0, 5, 6} {
+		server := engageServer(t, total, 3)
+
+		mix := New("product", "key", "secret")
+		mix.EngageURL = server.URL
+
+		output := make(chan EventData, 10)
+
+		num, err := mix.ExportProfiles(context.Background(), output, nil)
+		server.Close()
+		close(output)
+
+		if err != nil {
+			t.Fatalf("raised error: %v", err)
+		} else if num != total {
+			t.Errorf("expected %d profiles, got %d", total, num)
+		}
+
+		i := 0
+		for record := range output {
+			id := fmt.Sprintf("u%d", i)
+
+			if record[DistinctIDKey] != id || record[EventIDKey] != id {
+				t.Errorf("bad distinct ID: %v", record)
+			}
+
+			if record["$email"] != id+"@example.com" || record["event"] != ProfileEvent || record["product"] != "product" {
+				t.Errorf("bad profile record: %v", record)
+			}
+
+			i++
+		}
+	}
+}
+
+func TestExportProfilesApiError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"error": "bad where", "status": "error"}`)
+	}))
+	defer server.Close()
+
+	mix := New("product", "key", "secret")
+	mix.EngageURL = server.URL
+
+	_, err := mix.ExportProfiles(context.Background(), make(chan EventData), nil)
+	if err == nil || err.Error() != "product: API error: 400 Bad Request: bad where" {
+		t.Errorf("expected API error, got %v", err)
+	}
+}
@@ -0,0 +1,194 @@
+package mixpanel
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+var (
+	// ErrRateLimited is the `Err` of an APIError for a request that was
+	// still being rate limited once the retries were used up.
+	ErrRateLimited = errors.New("rate limited")
+
+	// ErrUnauthorized is the `Err` of an APIError for a request whose
+	// credentials were rejected.
+	ErrUnauthorized = errors.New("unauthorized")
+)
+
+// APIError is returned when the API refuses a request, either with a status
+// other than 200 OK, or with an error object in place of a record. For the
+// latter, `StatusCode` is 200 and `Body` is the error message.
+//
+// `Err` is ErrRateLimited or ErrUnauthorized for the respective statuses, and
+// nil otherwise. Callers check it the same way as the `Err` of an
+// os.PathError:
+//
+//	if err, ok := err.(*mixpanel.APIError); ok && err.Err == mixpanel.ErrUnauthorized {
+//		...
+//	}
+type APIError struct {
+	Product    string
+	StatusCode int
+	Body       string
+	Err        error
+
+	// Number of attempts made before giving up.
+	attempts int
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("%s: API error: ", e.Product)
+
+	if e.StatusCode == http.StatusOK {
+		return msg + e.Body
+	}
+
+	msg += fmt.Sprintf("%d %s", e.StatusCode, http.StatusText(e.StatusCode))
+
+	if e.Body != "" {
+		msg += ": " + e.Body
+	}
+
+	if e.attempts > 1 {
+		msg += fmt.Sprintf(" (giving up after %d attempts)", e.attempts)
+	}
+
+	return msg
+}
+
+// Unwrap returns `e.Err`, for errors.Is.
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
+// ParseError is returned for a record that isn't valid JSON.
+//
+// `Line` is the line of the record within the response or input, starting at
+// 1, and `Data` its contents. Both are only known for line based data, i.e.
+// event data, and zero otherwise.
+type ParseError struct {
+	Product string
+	Line    int
+	Data    []byte
+	Err     error
+}
+
+func (e *ParseError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("%s: Failed to parse JSON on line %d: %s", e.Product, e.Line, e.Err)
+	}
+
+	return fmt.Sprintf("%s: Failed to parse JSON: %s", e.Product, e.Err)
+}
+
+// Unwrap returns `e.Err`, for errors.Is.
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// NetworkError is returned when a request couldn't be made at all, e.g.
+// because the connection was refused or timed out, even after retrying.
+// `Err` is the error of the last attempt, usually a *url.Error.
+type NetworkError struct {
+	Product string
+	Err     error
+
+	// Number of attempts made before giving up.
+	attempts int
+}
+
+func (e *NetworkError) Error() string {
+	msg := fmt.Sprintf("%s: network error: %s", e.Product, e.Err)
+
+	if e.attempts > 1 {
+		msg += fmt.Sprintf(" (giving up after %d attempts)", e.attempts)
+	}
+
+	return msg
+}
+
+// Unwrap returns `e.Err`, for errors.Is.
+func (e *NetworkError) Unwrap() error {
+	return e.Err
+}
+
+// Timeout reports whether the last attempt timed out.
+func (e *NetworkError) Timeout() bool {
+	t, ok := e.Err.(interface {
+		Timeout() bool
+	})
+	return ok && t.Timeout()
+}
+
+// maxErrorBody caps how much of an error response is kept in an APIError.
+const maxErrorBody = 1024
+
+// statusError turns a response with a status other than 200 OK into an
+// APIError, consuming and closing its body.
+//
+// Mixpanel describes most errors with an object like `{"error": "..."}`, in
+// which case only the message is kept.
+func (m *Mixpanel) statusError(resp *http.Response) *APIError {
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	resp.Body.Close()
+
+	err := &APIError{
+		Product:    m.Product,
+		StatusCode: resp.StatusCode,
+		Body:       strings.TrimSpace(string(body)),
+	}
+
+	var object struct{ Error string }
+	if json.Unmarshal(body, &object) == nil && object.Error != "" {
+		err.Body = object.Error
+	}
+
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		err.Err = ErrRateLimited
+	case http.StatusUnauthorized, http.StatusForbidden:
+		err.Err = ErrUnauthorized
+	}
+
+	return err
+}
+
+// failed prefixes an error from a request with the product and what failed,
+// unless it's an APIError or NetworkError, which say as much already, or the
+// error of a cancelled context. These have to reach the caller intact.
+func (m *Mixpanel) failed(what string, err error) error {
+	switch err.(type) {
+	case *APIError, *NetworkError:
+		return err
+	}
+
+	if err == context.Canceled || err == context.DeadlineExceeded {
+		return err
+	}
+
+	return fmt.Errorf("%s: %s failed: %s", m.Product, what, err)
+}
+
+// tooLarge reports whether a request plausibly failed because of how much
+// data it asked for, so that splitting it up may help: Mixpanel answered with
+// an error object instead of records, or with 413 Request Entity Too Large or
+// 504 Gateway Timeout, or the request timed out.
+func tooLarge(err error) bool {
+	switch err := err.(type) {
+	case *APIError:
+		switch err.StatusCode {
+		case http.StatusOK, http.StatusRequestEntityTooLarge, http.StatusGatewayTimeout:
+			return true
+		}
+	case *NetworkError:
+		return err.Timeout()
+	}
+
+	return false
+}
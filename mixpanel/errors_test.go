@@ -0,0 +1,138 @@
+package mixpanel
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatusErrors(t *testing.T) {
+	cases := []struct {
+		Status int
+		Body   string
+		Err    error
+		Error  string
+	}{
+		{http.StatusUnauthorized, `{"error": "Invalid API secret"}`, ErrUnauthorized, "product: API error: 401 Unauthorized: Invalid API secret"},
+		{http.StatusForbidden, "", ErrUnauthorized, "product: API error: 403 Forbidden"},
+		{http.StatusTooManyRequests, "slow down\n", ErrRateLimited, "product: API error: 429 Too Many Requests: slow down (giving up after 2 attempts)"},
+		{http.StatusBadRequest, "<html>bad</html>", nil, "product: API error: 400 Bad Request: <html>bad</html>"},
+	}
+
+	for _, c := range cases {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(c.Status)
+			fmt.Fprint(w, c.Body)
+		}))
+
+		mix := NewWithURL("product", "key", "secret", server.URL)
+		mix.Retry = RetryPolicy{Retries: 1, MinWait: time.Millisecond, MaxWait: time.Millisecond}
+
+		date, _ := time.Parse("2006-01-02", "2014-01-01")
+
+		_, err := mix.ExportDate(date, make(chan EventData, 1), nil)
+		server.Close()
+
+		apiErr, ok := err.(*APIError)
+		if !ok {
+			t.Errorf("%d: expected an APIError, got %v", c.Status, err)
+			continue
+		}
+
+		if apiErr.StatusCode != c.Status || apiErr.Err != c.Err {
+			t.Errorf("%d: unexpected error: %#v", c.Status, apiErr)
+		}
+
+		if apiErr.Error() != c.Error {
+			t.Errorf("%d: bad error string: %q", c.Status, apiErr.Error())
+		}
+	}
+}
+
+func TestNetworkError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Close()
+
+	mix := NewWithURL("product", "key", "secret", server.URL)
+	mix.Retry = RetryPolicy{Retries: 1, MinWait: time.Millisecond, MaxWait: time.Millisecond}
+
+	date, _ := time.Parse("2006-01-02", "2014-01-01")
+
+	_, err := mix.ExportDate(date, make(chan EventData, 1), nil)
+
+	netErr, ok := err.(*NetworkError)
+	if !ok {
+		t.Fatalf("expected a NetworkError, got %v", err)
+	}
+
+	if _, ok := netErr.Err.(*url.Error); !ok {
+		t.Errorf("expected the underlying *url.Error, got %#v", netErr.Err)
+	}
+
+	if !strings.HasPrefix(err.Error(), "product: network error: ") || !strings.HasSuffix(err.Error(), "(giving up after 2 attempts)") {
+		t.Errorf("bad error string: %q", err.Error())
+	}
+}
+
+func TestParseError(t *testing.T) {
+	mix := New("product", "", "")
+	input := strings.NewReader(`{"event": "a", "properties": {}}
+
+{"event": "b", "properties": {
+`)
+
+	_, err := mix.TransformEventData(input, make(chan EventData, 1))
+
+	parseErr, ok := err.(*ParseError)
+	if !ok {
+		t.Fatalf("expected a ParseError, got %v", err)
+	}
+
+	if parseErr.Line != 3 || string(parseErr.Data) != `{"event": "b", "properties": {` {
+		t.Errorf("unexpected error: %#v", parseErr)
+	}
+
+	if !strings.HasPrefix(err.Error(), "product: Failed to parse JSON on line 3: ") {
+		t.Errorf("bad error string: %q", err.Error())
+	}
+}
+
+func TestParseErrorTrailingData(t *testing.T) {
+	mix := New("product", "", "")
+	input := strings.NewReader(`{"event": "a", "properties": {}} {"event": "b"}`)
+
+	if _, err := mix.TransformEventData(input, make(chan EventData, 1)); err == nil {
+		t.Error("expected error for two records on a line")
+	} else if _, ok := err.(*ParseError); !ok {
+		t.Errorf("expected a ParseError, got %v", err)
+	}
+}
+
+func TestExportRangeRefused(t *testing.T) {
+	requests := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	mix := NewWithURL("product", "key", "secret", server.URL)
+	mix.MaxWindow = 4
+
+	start, _ := time.Parse("2006-01-02", "2014-01-01")
+
+	_, err := mix.ExportRange(context.Background(), start, start.AddDate(0, 0, 3), make(chan EventData, 1), nil)
+	if apiErr, ok := err.(*APIError); !ok || apiErr.Err != ErrUnauthorized {
+		t.Errorf("expected ErrUnauthorized, got %v", err)
+	}
+
+	if requests != 1 {
+		t.Errorf("expected the range not to be split, saw %d requests", requests)
+	}
+}
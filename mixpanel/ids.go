@@ -0,0 +1,84 @@
+package mixpanel
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/nu7hatch/gouuid"
+)
+
+// IDStrategy selects how the ID stored under EventIDKey is generated.
+type IDStrategy int
+
+const (
+	// RandomIDs assigns each record a random (version 4) UUID, so
+	// exporting the same data twice yields different IDs.
+	RandomIDs IDStrategy = iota
+
+	// InsertIDs derives the ID from the record's `$insert_id` property,
+	// which Mixpanel uses for deduplication, falling back to hashing the
+	// record's contents when it has none.
+	InsertIDs
+
+	// ContentIDs derives the ID from the record's contents alone: the
+	// product, event name and all properties.
+	ContentIDs
+)
+
+// ParseIDStrategy converts the name of an IDStrategy ("random", "insertid" or
+// "content") into its value.
+func ParseIDStrategy(name string) (IDStrategy, error) {
+	switch name {
+	case "random":
+		return RandomIDs, nil
+	case "insertid":
+		return InsertIDs, nil
+	case "content":
+		return ContentIDs, nil
+	}
+
+	return RandomIDs, fmt.Errorf("unknown ID strategy: %s", name)
+}
+
+// Namespace for the version 5 UUIDs generated for deterministic IDs, so they
+// can't collide with UUIDs generated for other purposes.
+var idNamespace, _ = uuid.ParseHex("5b0e6d0a-3c1e-4a57-9d47-6d6978706f72")
+
+// eventID generates the ID of a record according to the client's IDStrategy.
+// Must be called before any keys are added to `properties`.
+//
+// Deterministic IDs are version 5 UUIDs, which keeps them the same shape as
+// random ones. The product name is always part of the hashed data, since
+// `$insert_id` is only unique within a single project.
+func (m *Mixpanel) eventID(event string, properties map[string]interface{}) (string, error) {
+	if m.IDs == RandomIDs {
+		id, err := uuid.NewV4()
+		if err != nil {
+			return "", err
+		}
+		return id.String(), nil
+	}
+
+	var name bytes.Buffer
+
+	if insertID, ok := properties["$insert_id"].(string); m.IDs == InsertIDs && ok && insertID != "" {
+		fmt.Fprintf(&name, "%s\x00insert_id\x00%s", m.Product, insertID)
+	} else {
+		// Maps are encoded with sorted keys, so this is a canonical
+		// representation of the properties.
+		props, err := json.Marshal(properties)
+		if err != nil {
+			return "", err
+		}
+
+		fmt.Fprintf(&name, "%s\x00event\x00%s\x00", m.Product, event)
+		name.Write(props)
+	}
+
+	id, err := uuid.NewV5(idNamespace, name.Bytes())
+	if err != nil {
+		return "", err
+	}
+
+	return id.String(), nil
+}
@@ -0,0 +1,80 @@
+package mixpanel
+
+import (
+	"strings"
+	"testing"
+)
+
+// transformOne runs a single line of input through TransformEventData.
+func transformOne(t *testing.T, mix *Mixpanel, line string) EventData {
+	output := make(chan EventData, 1)
+
+	if _, err := mix.TransformEventData(strings.NewReader(line), output); err != nil {
+		t.Fatalf("raised error: %v", err)
+	}
+
+	return <-output
+}
+
+func TestRandomIDs(t *testing.T) {
+	mix := New("product", "", "")
+	line := `{"event": "a", "properties": {"time": 1, "distinct_id": "x"}}`
+
+	if transformOne(t, mix, line)[EventIDKey] == transformOne(t, mix, line)[EventIDKey] {
+		t.Error("expected random IDs to differ")
+	}
+}
+
+func TestContentIDs(t *testing.T) {
+	mix := New("product", "", "")
+	mix.IDs = ContentIDs
+
+	a := transformOne(t, mix, `{"event": "a", "properties": {"time": 1, "distinct_id": "x", "k": [1, 2]}}`)
+	b := transformOne(t, mix, `{"event": "a", "properties": {"k": [1, 2], "distinct_id": "x", "time": 1}}`)
+	c := transformOne(t, mix, `{"event": "b", "properties": {"time": 1, "distinct_id": "x", "k": [1, 2]}}`)
+
+	if a[EventIDKey] != b[EventIDKey] {
+		t.Errorf("expected identical records to get the same ID: %s != %s", a[EventIDKey], b[EventIDKey])
+	}
+
+	if a[EventIDKey] == c[EventIDKey] {
+		t.Error("expected different events to get different IDs")
+	}
+
+	other := New("other", "", "")
+	other.IDs = ContentIDs
+
+	if d := transformOne(t, other, `{"event": "a", "properties": {"time": 1, "distinct_id": "x", "k": [1, 2]}}`); d[EventIDKey] == a[EventIDKey] {
+		t.Error("expected different products to get different IDs")
+	}
+}
+
+func TestInsertIDs(t *testing.T) {
+	mix := New("product", "", "")
+	mix.IDs = InsertIDs
+
+	a := transformOne(t, mix, `{"event": "a", "properties": {"time": 1, "$insert_id": "abc"}}`)
+	b := transformOne(t, mix, `{"event": "a", "properties": {"time": 2, "$insert_id": "abc"}}`)
+
+	if a[EventIDKey] != b[EventIDKey] {
+		t.Error("expected records with the same $insert_id to get the same ID")
+	}
+
+	// Falls back to hashing the contents.
+	c := transformOne(t, mix, `{"event": "a", "properties": {"time": 1}}`)
+	d := transformOne(t, mix, `{"event": "a", "properties": {"time": 1}}`)
+
+	if c[EventIDKey] != d[EventIDKey] {
+		t.Error("expected identical records without $insert_id to get the same ID")
+	}
+}
+
+func TestParseIDStrategy(t *testing.T) {
+	if ids, err := ParseIDStrategy("insertid"); err != nil || ids != InsertIDs {
+		t.Errorf("bad strategy: %v, %v", ids, err)
+	}
+
+	if _, err := ParseIDStrategy("bogus"); err == nil {
+		t.Error("expected error for unknown strategy")
+	}
+}
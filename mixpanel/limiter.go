@@ -0,0 +1,117 @@
+package mixpanel
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// Limiter keeps a set of clients within Mixpanel's API rate limits. A single
+// Limiter is meant to be shared by every client in the process, see
+// `Mixpanel.Limiter`.
+//
+// A request counts against the concurrency limit from the moment it's sent
+// until its response body is closed.
+type Limiter struct {
+	slots   chan struct{}
+	perHour int
+
+	mu     sync.Mutex
+	starts []time.Time
+}
+
+// NewLimiter creates a Limiter allowing at most `maxConcurrent` requests to
+// be in progress at once, and at most `maxPerHour` requests to be started in
+// any one hour window. Zero disables the respective limit.
+func NewLimiter(maxConcurrent, maxPerHour int) *Limiter {
+	l := &Limiter{perHour: maxPerHour}
+
+	if maxConcurrent > 0 {
+		l.slots = make(chan struct{}, maxConcurrent)
+	}
+
+	return l
+}
+
+// acquire blocks until a request may be made, or `ctx` is cancelled.
+//
+// `waiting` is called with the expected delay whenever the hourly limit has
+// been hit. On success, the returned function must be called once the
+// request is finished.
+func (l *Limiter) acquire(ctx context.Context, waiting func(time.Duration)) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+
+	if l.slots != nil {
+		select {
+		case l.slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	release := func() {
+		if l.slots != nil {
+			<-l.slots
+		}
+	}
+
+	for {
+		wait := l.reserve(time.Now())
+		if wait <= 0 {
+			return release, nil
+		}
+
+		waiting(wait)
+
+		timer := time.NewTimer(wait)
+
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			release()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// reserve records a request starting at `now` if that doesn't exceed the
+// hourly limit. Otherwise, nothing is recorded and the time until the next
+// request may be made is returned.
+func (l *Limiter) reserve(now time.Time) time.Duration {
+	if l.perHour <= 0 {
+		return 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Forget about requests that have left the window.
+	cutoff := now.Add(-time.Hour)
+	for len(l.starts) > 0 && !l.starts[0].After(cutoff) {
+		l.starts = l.starts[1:]
+	}
+
+	if len(l.starts) < l.perHour {
+		l.starts = append(l.starts, now)
+		return 0
+	}
+
+	return l.starts[0].Sub(cutoff)
+}
+
+// releaseOnClose calls `release` the first time the wrapped body is closed.
+type releaseOnClose struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (r *releaseOnClose) Close() error {
+	err := r.ReadCloser.Close()
+	r.once.Do(r.release)
+	return err
+}
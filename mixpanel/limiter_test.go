@@ -0,0 +1,104 @@
+package mixpanel
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLimiterReserve(t *testing.T) {
+	l := NewLimiter(0, 2)
+	now := time.Now()
+
+	if wait := l.reserve(now); wait != 0 {
+		t.Errorf("expected first request to pass, got wait %s", wait)
+	}
+
+	if wait := l.reserve(now.Add(10 * time.Minute)); wait != 0 {
+		t.Errorf("expected second request to pass, got wait %s", wait)
+	}
+
+	if wait := l.reserve(now.Add(20 * time.Minute)); wait != 40*time.Minute {
+		t.Errorf("expected to wait 40m, got %s", wait)
+	}
+
+	if wait := l.reserve(now.Add(time.Hour)); wait != 0 {
+		t.Errorf("expected request to pass after window, got wait %s", wait)
+	}
+}
+
+func TestLimiterCancel(t *testing.T) {
+	l := NewLimiter(1, 0)
+
+	release, err := l.acquire(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := l.acquire(ctx, nil); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+
+	release()
+
+	if _, err := l.acquire(context.Background(), nil); err != nil {
+		t.Errorf("expected slot to be free after release, got %v", err)
+	}
+}
+
+func TestLimiterConcurrency(t *testing.T) {
+	var (
+		mu                sync.Mutex
+		active, maxActive int
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		active++
+		if active > maxActive {
+			maxActive = active
+		}
+		mu.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+		fmt.Fprintln(w, `{"event": "a", "properties": {}}`)
+
+		mu.Lock()
+		active--
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	limiter := NewLimiter(2, 0)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			mix := NewWithURL("product", "key", "secret", server.URL)
+			mix.Limiter = limiter
+
+			resp, err := mix.get(context.Background(), server.URL, url.Values{})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+		}()
+	}
+	wg.Wait()
+
+	if maxActive > 2 {
+		t.Errorf("expected at most 2 concurrent requests, saw %d", maxActive)
+	}
+}
@@ -1,36 +1,106 @@
 package mixpanel
 
 import (
-	"crypto/md5"
-	"encoding/json"
+	"context"
 	"fmt"
-	"github.com/nu7hatch/gouuid"
 	"io"
+	"log"
 	"net/http"
 	"net/url"
-	"sort"
-	"strings"
+	"sync"
 	"time"
 )
 
 // The official base URL
 const MixpanelBaseURL = "https://data.mixpanel.com/api/2.0/export"
 
+// RegionBaseURLs maps each of Mixpanel's data residency regions to its export
+// API endpoint. A project's data can only be exported from the region it's
+// stored in.
+var RegionBaseURLs = map[string]string{
+	"us": MixpanelBaseURL,
+	"eu": "https://data-eu.mixpanel.com/api/2.0/export",
+	"in": "https://data-in.mixpanel.com/api/2.0/export",
+}
+
 // Key into the EventData map that contains the UUID of this event. Name is
 // chosen to make collisions with actual keys very unlikely.
 const EventIDKey = "$__$$event_id"
 
-// Key into the EventData map that contains the SQL Timestamp/DateTime
-// compatible timestamp of this event.
+// Key into the EventData map that contains the UTC timestamp of this event,
+// by default in a SQL Timestamp/DateTime compatible format.
 const TimestampKey = "$__$$timestamp"
 
 // Mixpanel struct represents a set of credentials used to access the Mixpanel
 // API for a particular product.
+//
+// - `BaseURL`, `EngageURL` and `CohortsURL` are the endpoints of the raw
+//   event export, the people profiles (Engage) and the cohort listing API.
+//   `AppURL` is the base URL of the app API (e.g. Lexicon schemas). See
+//   SetRegion.
+// - `Auth` attaches credentials to each request. If nil, requests are signed
+//   with `Key` and `Secret` (see SignatureAuth).
+// - `Client` is used to make the HTTP requests. If nil, http.DefaultClient
+//   is used, which has no timeouts. See NewHTTPClient.
+// - `MaxWindow` is the largest number of days ExportRange requests at once.
+// - `Transform` selects the shape of the records, flattened by default.
+//   `Collisions` decides what happens to properties clashing with the keys
+//   added by flattening, see CollisionCounts.
+// - `IDs` selects how the ID under EventIDKey is generated, random by
+//   default.
+// - `Location` is the project's timezone, UTC if nil.
+// - `TimestampFormat` selects how timestamps are rendered. If
+//   `LocalTimestamps` is set, the event's time in `Location` is added under
+//   LocalTimestampKey as well.
+// - `Limiter`, if not nil, is consulted before every request. It should be
+//   shared by all clients to keep the process as a whole within Mixpanel's
+//   rate limits.
+// - `Retry` controls how failed requests are retried, and how many times a
+//   truncated response is resumed.
+// - `ExpectedCount`, if not nil, is asked how many records an export should
+//   yield once its response has been read. Fewer records than that are
+//   treated like a truncated response.
+// - `Rejects` lets exports skip over malformed records instead of failing,
+//   see RejectPolicy and RejectCount.
+// - `RecordDir`, if set, is where every response received in full is saved,
+//   error responses included, in a file per product and request. `ReplayDir`
+//   makes the client serve responses from such a directory instead of making
+//   requests, so that errors (e.g. ones shrinking the window of ExportRange)
+//   play out the same way again.
+// - `Logger`, if not nil, receives progress messages and failed attempts.
 type Mixpanel struct {
-	Product string
-	Key     string
-	Secret  string
-	BaseURL string
+	Product    string
+	Key        string
+	Secret     string
+	BaseURL    string
+	EngageURL  string
+	CohortsURL string
+	AppURL     string
+	Auth       Authenticator
+	Client     *http.Client
+
+	Transform       TransformMode
+	Collisions      CollisionPolicy
+	IDs             IDStrategy
+	Location        *time.Location
+	TimestampFormat TimestampFormat
+	LocalTimestamps bool
+
+	MaxWindow int
+	Limiter   *Limiter
+	Retry     RetryPolicy
+	Logger    *log.Logger
+
+	RecordDir, ReplayDir string
+
+	ExpectedCount CountFunc
+	Rejects       RejectPolicy
+
+	collisionsMu sync.Mutex
+	collisions   map[[2]string]int
+
+	rejectsMu          sync.Mutex
+	rejected, accepted int
 }
 
 // EventData is a representation of each individual JSON record spit out of the
@@ -53,45 +123,44 @@ func NewWithURL(product, key, secret, baseURL string) *Mixpanel {
 	m.Key = key
 	m.Secret = secret
 	m.BaseURL = baseURL
+	m.EngageURL = MixpanelEngageURL
+	m.CohortsURL = MixpanelCohortsURL
+	m.AppURL = MixpanelAppURL
+	m.Retry = DefaultRetryPolicy
 	return m
 }
 
-// Add the cryptographic signature that Mixpanel API requests require.
-//
-// Algorithm:
-// - join key=value pairs
-// - sort the pairs alphabetically
-// - appending a secret
-// - take MD5 hex digest.
-func (m *Mixpanel) addSignature(args *url.Values) {
-	hash := md5.New()
-
-	var params []string
-	for k, vs := range *args {
-		for _, v := range vs {
-			params = append(params, fmt.Sprintf("%s=%s", k, v))
-		}
+// SetRegion points the client at the API endpoints of the given data
+// residency region ("us", "eu" or "in").
+func (m *Mixpanel) SetRegion(region string) error {
+	baseURL, ok := RegionBaseURLs[region]
+	if !ok {
+		return fmt.Errorf("unknown region: %s", region)
 	}
 
-	sort.StringSlice(params).Sort()
+	m.BaseURL = baseURL
+	m.EngageURL = RegionEngageURLs[region]
+	m.CohortsURL = RegionCohortsURLs[region]
+	m.AppURL = RegionAppURLs[region]
+	return nil
+}
 
-	io.WriteString(hash, strings.Join(params, "")+m.Secret)
-	args.Set("sig", fmt.Sprintf("%x", hash.Sum(nil)))
+// logf writes a message to the client's Logger, if one is set.
+func (m *Mixpanel) logf(format string, args ...interface{}) {
+	if m.Logger != nil {
+		m.Logger.Printf(format, args...)
+	}
 }
 
 // Generate the initial, base arguments that should be common to all Mixpanel
-// API requests being created here.
-func (m *Mixpanel) makeArgs(date time.Time) url.Values {
+// API requests being created here, covering the days from `from` to `to`
+// inclusive.
+func (m *Mixpanel) makeArgs(from, to time.Time) url.Values {
 	args := url.Values{}
 
 	args.Set("format", "json")
-	args.Set("api_key", m.Key)
-	args.Set("expire", fmt.Sprintf("%d", time.Now().Unix()+10000))
-
-	day := date.Format("2006-01-02")
-
-	args.Set("from_date", day)
-	args.Set("to_date", day)
+	args.Set("from_date", from.Format("2006-01-02"))
+	args.Set("to_date", to.Format("2006-01-02"))
 
 	return args
 }
@@ -105,85 +174,57 @@ func (m *Mixpanel) makeArgs(date time.Time) url.Values {
 //
 // The optional `moreArgs` parameter can be given to add additional URL
 // parameters to the API request.
+//
+// Connection failures and transient HTTP errors (rate limiting, 5xx) are
+// retried according to `m.Retry` before any data is streamed. Requests the
+// API refuses are reported as an *APIError, requests that can't be made at
+// all as a *NetworkError, and records that aren't valid JSON as a
+// *ParseError.
 func (m *Mixpanel) ExportDate(date time.Time, output chan<- EventData, moreArgs *url.Values) (int, error) {
-	args := m.makeArgs(date)
-
-	if moreArgs != nil {
-		for k, vs := range *moreArgs {
-			for _, v := range vs {
-				args.Add(k, v)
-			}
-		}
-	}
-
-	m.addSignature(&args)
+	return m.ExportDateContext(context.Background(), date, output, moreArgs)
+}
 
-	resp, err := http.Get(fmt.Sprintf("%s?%s", m.BaseURL, args.Encode()))
+// ExportDateContext is like ExportDate, but aborts the download and stops
+// sending on `output` as soon as `ctx` is cancelled, in which case the
+// context's error is returned.
+func (m *Mixpanel) ExportDateContext(ctx context.Context, date time.Time, output chan<- EventData, moreArgs *url.Values) (int, error) {
+	return m.exportWindow(ctx, date, date, output, moreArgs)
+}
 
+// exportWindow does the actual work of ExportDateContext for an arbitrary
+// span of days.
+func (m *Mixpanel) exportWindow(ctx context.Context, from, to time.Time, output chan<- EventData, moreArgs *url.Values) (int, error) {
+	reader, err := m.openWindow(ctx, from, to, moreArgs)
 	if err != nil {
-		return 0, fmt.Errorf("%s: download failed: %s", m.Product, err)
+		return 0, err
 	}
 
-	defer resp.Body.Close()
+	defer reader.Close()
 
-	return m.TransformEventData(resp.Body, output)
+	return reader.send(ctx, output)
 }
 
 // TransformEventData reads JSON objects line by line from `input`, performs a
 // simple translation, and pipes the result back out through the `output` chan.
+// It's a thin wrapper around EventReader.
 //
-// The transformation effectively folds the properties map into the top level
-// and attaches product information.
+// By default, the transformation effectively folds the properties map into
+// the top level and attaches product information. See `m.Transform` for the
+// alternatives.
 //
 // Returns the number of records that have been processed during the run and
-// possibly an error.
+// possibly an error: an *APIError for error objects in the input, and a
+// *ParseError for lines that aren't valid JSON.
 //
 // Input : `{"event": "...", "properties": {"k": "v"}}`
 // Output: `{"event": "...", "product: "...", "k": "v", ...}`
 func (m *Mixpanel) TransformEventData(input io.Reader, output chan<- EventData) (int, error) {
-	decoder := json.NewDecoder(input)
-
-	// Don't default all numeric values to float
-	decoder.UseNumber()
-
-	// Keep track of the number of records we've processed.
-	numLines := 0
-
-	for ; ; numLines++ {
-		var ev struct {
-			Error      *string
-			Event      string
-			Properties map[string]interface{}
-		}
-
-		if err := decoder.Decode(&ev); err == io.EOF {
-			break
-		} else if err != nil {
-			return numLines, fmt.Errorf("%s: Failed to parse JSON: %s", m.Product, err)
-		} else if ev.Error != nil {
-			return numLines, fmt.Errorf("%s: API error: %s", m.Product, *ev.Error)
-		}
-
-		if id, err := uuid.NewV4(); err == nil {
-			ev.Properties[EventIDKey] = id.String()
-		} else {
-			return numLines, fmt.Errorf("%s: generating UUID failed: %s", m.Product, err)
-		}
-
-		if prop, ok := ev.Properties["time"].(json.Number); ok {
-			if uts, err := prop.Int64(); err == nil {
-				tstamp := time.Unix(uts, 0).UTC()
-				ev.Properties[TimestampKey] = tstamp.Format("2006-01-02 15:04:05")
-			} else {
-				return numLines, fmt.Errorf("%s: converting Timestamp failed: %s", m.Product, err)
-			}
-		}
-
-		ev.Properties["product"] = m.Product
-		ev.Properties["event"] = ev.Event
-
-		output <- ev.Properties
-	}
+	return m.TransformEventDataContext(context.Background(), input, output)
+}
 
-	return numLines, nil
+// TransformEventDataContext is like TransformEventData, but stops reading
+// `input` and sending on `output` once `ctx` is cancelled, returning the
+// context's error.
+func (m *Mixpanel) TransformEventDataContext(ctx context.Context, input io.Reader, output chan<- EventData) (int, error) {
+	return m.newEventReader(ctx, input).send(ctx, output)
 }
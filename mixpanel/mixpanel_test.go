@@ -1,24 +1,22 @@
 package mixpanel
 
 import (
+	"context"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 	"time"
 )
 
-func TestAddSignature(t *testing.T) {
-	// TODO: write me
-}
-
 func TestMakeArgs(t *testing.T) {
 	mix := New("product", "key", "secret")
 	date, _ := time.Parse("2006-01-02", "1999-12-31")
-	args := mix.makeArgs(date)
+	args := mix.makeArgs(date, date)
 
 	expected := [][]string{
 		{"format", "json"},
-		{"api_key", "key"},
 		{"from_date", "1999-12-31"},
 		{"to_date", "1999-12-31"},
 	}
@@ -30,6 +28,22 @@ func TestMakeArgs(t *testing.T) {
 	}
 }
 
+func TestSetRegion(t *testing.T) {
+	mix := New("product", "key", "secret")
+
+	if err := mix.SetRegion("eu"); err != nil {
+		t.Errorf("raised error: %v", err)
+	} else if mix.BaseURL != "https://data-eu.mixpanel.com/api/2.0/export" {
+		t.Errorf("bad base URL for eu: %s", mix.BaseURL)
+	} else if mix.EngageURL != "https://eu.mixpanel.com/api/2.0/engage" {
+		t.Errorf("bad Engage URL for eu: %s", mix.EngageURL)
+	}
+
+	if err := mix.SetRegion("mars"); err == nil {
+		t.Error("expected error for unknown region")
+	}
+}
+
 func TestTransformEventData(t *testing.T) {
 	mix := New("product", "", "")
 	input := strings.NewReader(`
@@ -132,10 +146,6 @@ func TestExtractUnixTimestamp(t *testing.T) {
 	}
 }
 
-func TestExportDate(t *testing.T) {
-	// TODO: write me
-}
-
 func BenchmarkTransformEventData(b *testing.B) {
 	mix := New("product", "", "")
 	input := strings.NewReader(
@@ -150,3 +160,42 @@ func BenchmarkTransformEventData(b *testing.B) {
 	}
 	close(output)
 }
+
+func TestTransformEventDataCancel(t *testing.T) {
+	mix := New("product", "", "")
+	input := strings.NewReader(`{"event": "a", "properties": {}}
+{"event": "b", "properties": {}}`)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// Nobody is receiving on the channel, so the transform can only return
+	// by noticing the cancellation.
+	output := make(chan EventData)
+	cancel()
+
+	if num, err := mix.TransformEventDataContext(ctx, input, output); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	} else if num != 0 {
+		t.Errorf("expected 0 records, got %d", num)
+	}
+}
+
+func TestExportDateContextCancel(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Hold on to the request until the client goes away.
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	mix := NewWithURL("product", "key", "secret", server.URL)
+	date, _ := time.Parse("2006-01-02", "2014-01-01")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	output := make(chan EventData, 1)
+
+	if _, err := mix.ExportDateContext(ctx, date, output, nil); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
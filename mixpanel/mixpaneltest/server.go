@@ -0,0 +1,378 @@
+// Package mixpaneltest provides a fake Mixpanel raw export API for testing
+// code built on the mixpanel package without network access.
+//
+// A Server serves NDJSON fixtures per product and date, checks the
+// credentials of each request like Mixpanel does, and can be told to
+// misbehave in the ways the real API does from time to time:
+//
+//	server := mixpaneltest.NewServer()
+//	defer server.Close()
+//
+//	server.AddProduct(mixpaneltest.Product{Name: "foo", Key: "key", Secret: "secret"})
+//	server.AddEvents("foo", "2014-01-01", `{"event": "a", "properties": {"time": 1388534400}}`)
+//	server.Inject(mixpaneltest.RateLimited(time.Second))
+//
+//	client := server.Client("foo")
+//	client.ExportDate(...)
+package mixpaneltest
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/erik/mixport/mixpanel"
+)
+
+// Product holds the credentials a Server accepts for a product.
+//
+// - `Key` and `Secret` are used for signed requests (mixpanel.SignatureAuth)
+//   and the secret alone for mixpanel.ProjectSecretAuth.
+// - If `Username` is set, service account requests (mixpanel.ServiceAccountAuth)
+//   using it, `Secret` and `ProjectID` are accepted as well.
+type Product struct {
+	Name      string
+	Key       string
+	Secret    string
+	Username  string
+	ProjectID string
+}
+
+// Fault describes how a Server should misbehave when serving a request, see
+// Server.Inject. The zero value is a well-behaved response.
+//
+// - `Status`, if not zero, is returned instead of the data, along with a
+//   `Retry-After` header if `RetryAfter` is set.
+// - `TruncateAfter`, if positive, cuts the connection after that many bytes
+//   of the body have been sent.
+// - `Delay` is waited before each line of the body.
+// - `Error`, if not empty, is sent as an `{"error": ...}` line after the
+//   data.
+type Fault struct {
+	Status        int
+	RetryAfter    time.Duration
+	TruncateAfter int
+	Delay         time.Duration
+	Error         string
+}
+
+// RateLimited returns a Fault responding with 429 Too Many Requests.
+func RateLimited(retryAfter time.Duration) Fault {
+	return Fault{Status: http.StatusTooManyRequests, RetryAfter: retryAfter}
+}
+
+// ServerError returns a Fault responding with 500 Internal Server Error.
+func ServerError() Fault {
+	return Fault{Status: http.StatusInternalServerError}
+}
+
+// Truncated returns a Fault cutting the body short after `n` bytes.
+func Truncated(n int) Fault {
+	return Fault{TruncateAfter: n}
+}
+
+// Slow returns a Fault waiting `delay` before each line of the body.
+func Slow(delay time.Duration) Fault {
+	return Fault{Delay: delay}
+}
+
+// ErrorLine returns a Fault ending the body with an API error.
+func ErrorLine(message string) Fault {
+	return Fault{Error: message}
+}
+
+// Server is a fake Mixpanel raw export API.
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	products map[string]Product
+	events   map[string]map[string][]string
+	faults   []Fault
+	requests []string
+}
+
+// NewServer starts a Server without any products. The caller should call
+// Close when finished, to shut it down.
+func NewServer() *Server {
+	s := &Server{
+		products: make(map[string]Product),
+		events:   make(map[string]map[string][]string),
+	}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveExport))
+
+	return s
+}
+
+// AddProduct makes the server accept the product's credentials.
+func (s *Server) AddProduct(p Product) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.products[p.Name] = p
+	if s.events[p.Name] == nil {
+		s.events[p.Name] = make(map[string][]string)
+	}
+}
+
+// AddEvents appends lines of raw NDJSON to the data served for the product on
+// the given date ("2006-01-02"). Lines aren't validated, so malformed data
+// can be served as well.
+func (s *Server) AddEvents(product, date string, lines ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.events[product] == nil {
+		s.events[product] = make(map[string][]string)
+	}
+
+	s.events[product][date] = append(s.events[product][date], lines...)
+}
+
+// Inject queues up faults, each of which applies to a single one of the
+// following requests, in order.
+func (s *Server) Inject(faults ...Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.faults = append(s.faults, faults...)
+}
+
+// Requests returns the query strings of all requests received so far that
+// got past authentication.
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string(nil), s.requests...)
+}
+
+// Client returns a client for the product talking to the server, using
+// signature authentication. Retries are made without any noticeable delay.
+func (s *Server) Client(product string) *mixpanel.Mixpanel {
+	s.mu.Lock()
+	p := s.products[product]
+	s.mu.Unlock()
+
+	client := mixpanel.NewWithURL(product, p.Key, p.Secret, s.URL)
+	client.Retry = mixpanel.RetryPolicy{
+		Retries: mixpanel.DefaultRetryPolicy.Retries,
+		MinWait: time.Millisecond,
+		MaxWait: 10 * time.Millisecond,
+	}
+
+	return client
+}
+
+// authenticate finds the product whose credentials the request carries.
+func (s *Server) authenticate(r *http.Request) (Product, error) {
+	args := r.URL.Query()
+
+	if user, password, ok := r.BasicAuth(); ok {
+		for _, p := range s.products {
+			if p.Username != "" && user == p.Username && password == p.Secret {
+				if args.Get("project_id") != p.ProjectID {
+					return Product{}, fmt.Errorf("wrong project_id for service account")
+				}
+				return p, nil
+			}
+
+			if user == p.Secret && password == "" {
+				return p, nil
+			}
+		}
+
+		return Product{}, fmt.Errorf("invalid credentials")
+	}
+
+	var product *Product
+	for _, p := range s.products {
+		if p.Key != "" && p.Key == args.Get("api_key") {
+			p := p
+			product = &p
+		}
+	}
+
+	if product == nil {
+		return Product{}, fmt.Errorf("unknown api_key")
+	}
+
+	expire, err := strconv.ParseInt(args.Get("expire"), 10, 64)
+	if err != nil || expire < time.Now().Unix() {
+		return Product{}, fmt.Errorf("request expired")
+	}
+
+	if args.Get("sig") != signature(args, product.Secret) {
+		return Product{}, fmt.Errorf("invalid signature")
+	}
+
+	return *product, nil
+}
+
+// signature computes the signature of a request, ignoring its `sig`.
+func signature(args map[string][]string, secret string) string {
+	var params []string
+	for k, vs := range args {
+		if k == "sig" {
+			continue
+		}
+
+		for _, v := range vs {
+			params = append(params, k+"="+v)
+		}
+	}
+
+	sort.Strings(params)
+
+	return fmt.Sprintf("%x", md5.Sum([]byte(strings.Join(params, "")+secret)))
+}
+
+// writeError responds with an API error, which Mixpanel sends as JSON.
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
+
+func (s *Server) serveExport(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+
+	product, err := s.authenticate(r)
+	if err != nil {
+		s.mu.Unlock()
+		writeError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	s.requests = append(s.requests, r.URL.RawQuery)
+
+	var fault Fault
+	if len(s.faults) > 0 {
+		fault, s.faults = s.faults[0], s.faults[1:]
+	}
+
+	lines, err := s.lines(product.Name, r)
+	s.mu.Unlock()
+
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if fault.Status != 0 {
+		if fault.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int((fault.RetryAfter+time.Second-1)/time.Second)))
+		}
+
+		writeError(w, fault.Status, http.StatusText(fault.Status))
+		return
+	}
+
+	if fault.Error != "" {
+		message, _ := json.Marshal(map[string]string{"error": fault.Error})
+		lines = append(lines, string(message))
+	}
+
+	var body []byte
+	for _, line := range lines {
+		body = append(body, line...)
+		body = append(body, '\n')
+	}
+
+	truncated := fault.TruncateAfter > 0 && fault.TruncateAfter < len(body)
+	if truncated {
+		// Announce the full body, so the client can tell it's been
+		// cut short.
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		body = body[:fault.TruncateAfter]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	for len(body) > 0 {
+		n := len(body)
+
+		if fault.Delay > 0 {
+			n = bytes.IndexByte(body, '\n') + 1
+			if n == 0 {
+				n = len(body)
+			}
+
+			select {
+			case <-time.After(fault.Delay):
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+		if _, err := w.Write(body[:n]); err != nil {
+			return
+		}
+
+		w.(http.Flusher).Flush()
+		body = body[n:]
+	}
+
+	if truncated {
+		if conn, _, err := w.(http.Hijacker).Hijack(); err == nil {
+			conn.Close()
+		}
+	}
+}
+
+// lines collects the data requested by an export request: every day from
+// `from_date` to `to_date`, restricted to the events in `event`, if given.
+// The `where` argument isn't supported and ignored.
+func (s *Server) lines(product string, r *http.Request) ([]string, error) {
+	args := r.URL.Query()
+
+	from, err := time.Parse("2006-01-02", args.Get("from_date"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid from_date")
+	}
+
+	to, err := time.Parse("2006-01-02", args.Get("to_date"))
+	if err != nil || to.Before(from) {
+		return nil, fmt.Errorf("invalid to_date")
+	}
+
+	var events map[string]bool
+
+	if names := args.Get("event"); names != "" {
+		var list []string
+		if err := json.Unmarshal([]byte(names), &list); err != nil {
+			return nil, fmt.Errorf("invalid event")
+		}
+
+		events = make(map[string]bool)
+		for _, name := range list {
+			events[name] = true
+		}
+	}
+
+	var lines []string
+
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		for _, line := range s.events[product][day.Format("2006-01-02")] {
+			if events != nil {
+				var ev struct{ Event string }
+				if json.Unmarshal([]byte(line), &ev) != nil || !events[ev.Event] {
+					continue
+				}
+			}
+
+			lines = append(lines, line)
+		}
+	}
+
+	return lines, nil
+}
@@ -0,0 +1,148 @@
+package mixpaneltest
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/erik/mixport/mixpanel"
+)
+
+var date, _ = time.Parse("2006-01-02", "2014-01-01")
+
+func newServer() *Server {
+	s := NewServer()
+
+	s.AddProduct(Product{Name: "foo", Key: "key", Secret: "secret", Username: "sa", ProjectID: "1"})
+	s.AddEvents("foo", "2014-01-01",
+		`{"event": "a", "properties": {"time": 1388534400, "$insert_id": "1"}}`,
+		`{"event": "b", "properties": {"time": 1388534401, "$insert_id": "2"}}`,
+		`{"event": "a", "properties": {"time": 1388534402, "$insert_id": "3"}}`)
+	s.AddEvents("foo", "2014-01-02",
+		`{"event": "a", "properties": {"time": 1388620800, "$insert_id": "4"}}`)
+
+	return s
+}
+
+// export downloads the day, returning the `$insert_id`s of the records.
+func export(client *mixpanel.Mixpanel, opts *mixpanel.ExportOptions) (string, error) {
+	args := opts.Values()
+
+	reader, err := client.OpenDate(context.Background(), date, &args)
+	if err != nil {
+		return "", err
+	}
+
+	defer reader.Close()
+
+	var ids string
+
+	for {
+		ev, err := reader.Next()
+		if err == io.EOF {
+			return ids, nil
+		} else if err != nil {
+			return ids, err
+		}
+
+		ids += ev["$insert_id"].(string)
+	}
+}
+
+func TestExportDate(t *testing.T) {
+	s := newServer()
+	defer s.Close()
+
+	output := make(chan mixpanel.EventData, 10)
+
+	num, err := s.Client("foo").ExportDate(date, output, nil)
+	if err != nil {
+		t.Fatalf("raised error: %v", err)
+	} else if num != 3 {
+		t.Errorf("expected 3 records, got %d", num)
+	}
+
+	if ev := <-output; ev["event"] != "a" || ev["product"] != "foo" {
+		t.Errorf("bad record: %v", ev)
+	}
+}
+
+func TestEventFilter(t *testing.T) {
+	s := newServer()
+	defer s.Close()
+
+	if ids, err := export(s.Client("foo"), &mixpanel.ExportOptions{Events: []string{"a"}}); err != nil {
+		t.Errorf("raised error: %v", err)
+	} else if ids != "13" {
+		t.Errorf("expected only events a, got %s", ids)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	s := newServer()
+	defer s.Close()
+
+	auths := []struct {
+		Auth mixpanel.Authenticator
+		OK   bool
+	}{
+		{mixpanel.SignatureAuth{Key: "key", Secret: "secret"}, true},
+		{mixpanel.SignatureAuth{Key: "key", Secret: "wrong"}, false},
+		{mixpanel.SignatureAuth{Key: "wrong", Secret: "secret"}, false},
+		{mixpanel.ProjectSecretAuth{Secret: "secret"}, true},
+		{mixpanel.ProjectSecretAuth{Secret: "wrong"}, false},
+		{mixpanel.ServiceAccountAuth{Username: "sa", Secret: "secret", ProjectID: "1"}, true},
+		{mixpanel.ServiceAccountAuth{Username: "sa", Secret: "secret", ProjectID: "2"}, false},
+	}
+
+	for _, a := range auths {
+		client := s.Client("foo")
+		client.Auth = a.Auth
+
+		_, err := export(client, nil)
+		if a.OK && err != nil {
+			t.Errorf("%#v: raised error: %v", a.Auth, err)
+		} else if !a.OK && err == nil {
+			t.Errorf("%#v: expected to be refused", a.Auth)
+		}
+	}
+}
+
+func TestFaults(t *testing.T) {
+	cases := []struct {
+		Name     string
+		Faults   []Fault
+		Requests int
+		Error    string
+	}{
+		{"rate limited", []Fault{RateLimited(0), RateLimited(0)}, 3, ""},
+		{"server error", []Fault{ServerError()}, 2, ""},
+		{"gives up", []Fault{ServerError(), ServerError(), ServerError(), ServerError()}, 4, "giving up"},
+		{"truncated", []Fault{Truncated(100)}, 2, ""},
+		{"slow", []Fault{Slow(10 * time.Millisecond)}, 1, ""},
+		{"error line", []Fault{ErrorLine("oops")}, 1, "API error: oops"},
+	}
+
+	for _, c := range cases {
+		s := newServer()
+		s.Inject(c.Faults...)
+
+		ids, err := export(s.Client("foo"), nil)
+		requests := len(s.Requests())
+		s.Close()
+
+		if c.Error == "" && err != nil {
+			t.Errorf("%s: raised error: %v", c.Name, err)
+		} else if c.Error != "" && (err == nil || !strings.Contains(err.Error(), c.Error)) {
+			t.Errorf("%s: expected error %q, got %v", c.Name, c.Error, err)
+		} else if err == nil && ids != "123" {
+			t.Errorf("%s: expected every record once, got %s", c.Name, ids)
+		}
+
+		if requests != c.Requests {
+			t.Errorf("%s: expected %d requests, got %d", c.Name, c.Requests, requests)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package mixpanel
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ExportOptions narrows down the records returned by an export request.
+//
+// - `Events` limits the export to the given event names.
+// - `Where` is a Mixpanel segmentation expression that records must match,
+//   e.g. `properties["$os"] == "Linux"`.
+// - `Limit` caps the number of records returned. Zero means no limit.
+// - `Since` and `Until`, if not zero, restrict the export to records with a
+//   `time` in [Since, Until). This is useful to fetch less than a whole day.
+type ExportOptions struct {
+	Events       []string
+	Where        string
+	Limit        int
+	Since, Until time.Time
+}
+
+// Values encodes the options as URL parameters understood by the export API,
+// suitable for passing to ExportDate as `moreArgs`.
+func (o *ExportOptions) Values() url.Values {
+	args := url.Values{}
+
+	if o == nil {
+		return args
+	}
+
+	if len(o.Events) > 0 {
+		// Can't fail for a slice of strings.
+		events, _ := json.Marshal(o.Events)
+		args.Set("event", string(events))
+	}
+
+	if where := o.where(); where != "" {
+		args.Set("where", where)
+	}
+
+	if o.Limit > 0 {
+		args.Set("limit", strconv.Itoa(o.Limit))
+	}
+
+	return args
+}
+
+// where combines the user supplied expression with the time window into a
+// single expression.
+func (o *ExportOptions) where() string {
+	var clauses []string
+
+	if o.Where != "" {
+		clauses = append(clauses, fmt.Sprintf("(%s)", o.Where))
+	}
+
+	if !o.Since.IsZero() {
+		clauses = append(clauses,
+			fmt.Sprintf(`properties["time"] >= datetime(%d)`, o.Since.Unix()))
+	}
+
+	if !o.Until.IsZero() {
+		clauses = append(clauses,
+			fmt.Sprintf(`properties["time"] < datetime(%d)`, o.Until.Unix()))
+	}
+
+	// Don't wrap a lone user expression in redundant parentheses.
+	if len(clauses) == 1 && o.Where != "" {
+		return o.Where
+	}
+
+	return strings.Join(clauses, " and ")
+}
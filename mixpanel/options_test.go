@@ -0,0 +1,56 @@
+package mixpanel
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExportOptionsValues(t *testing.T) {
+	opts := &ExportOptions{
+		Events: []string{"a", "b c"},
+		Where:  `properties["$os"] == "Linux"`,
+		Limit:  10,
+	}
+
+	args := opts.Values()
+
+	expected := [][]string{
+		{"event", `["a","b c"]`},
+		{"where", `properties["$os"] == "Linux"`},
+		{"limit", "10"},
+	}
+
+	for _, pair := range expected {
+		if v := args.Get(pair[0]); v != pair[1] {
+			t.Errorf("Expected %v, got %s", pair, v)
+		}
+	}
+}
+
+func TestExportOptionsTimeWindow(t *testing.T) {
+	since := time.Unix(1000, 0)
+
+	opts := &ExportOptions{
+		Where: `properties["a"] == 1`,
+		Since: since,
+		Until: since.Add(time.Hour),
+	}
+
+	expected := `(properties["a"] == 1) and properties["time"] >= datetime(1000) and properties["time"] < datetime(4600)`
+
+	if where := opts.Values().Get("where"); where != expected {
+		t.Errorf("bad where expression: %s", where)
+	}
+}
+
+func TestExportOptionsEmpty(t *testing.T) {
+	var opts *ExportOptions
+
+	if args := opts.Values(); len(args) != 0 {
+		t.Errorf("expected no arguments, got %v", args)
+	}
+
+	if args := (&ExportOptions{}).Values(); len(args) != 0 {
+		t.Errorf("expected no arguments, got %v", args)
+	}
+}
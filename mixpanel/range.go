@@ -0,0 +1,133 @@
+package mixpanel
+
+import (
+	"context"
+	"time"
+)
+
+// ExportRange downloads event data for every day from `start` to `end`
+// (inclusive), streaming the transformed records over `output` like
+// ExportDate does.
+//
+// Rather than issuing a request per day, up to `m.MaxWindow` days are
+// requested at once. If a request fails before producing any records (for
+// example because Mixpanel refuses to serve that much data at once), the
+// window is halved and the request tried again, down to a single day. A day
+// that still fails is split further into chunks of hours, using a `where`
+// expression on the records' `time`. The window stays small for the rest of
+// the range once shrunk.
+//
+// Only failures that plausibly come down to the size of the response shrink
+// the window: an error object in place of the records, a 413 or 504 status,
+// or a timeout. Anything else, such as a *ParseError or an invalid `where`
+// expression, is something no smaller request is going to fix, and is
+// returned immediately. So are failures after records have already been sent
+// on `output`, which can't be recovered from this way without producing
+// duplicates.
+//
+// `opts.Since` and `opts.Until` apply to the whole range. Hour chunks only
+// request the part of the chunk within them, if any.
+func (m *Mixpanel) ExportRange(ctx context.Context, start, end time.Time, output chan<- EventData, opts *ExportOptions) (int, error) {
+	window := m.MaxWindow
+	if window < 1 {
+		window = 1
+	}
+
+	query := ExportOptions{}
+	if opts != nil {
+		query = *opts
+	}
+
+	args := query.Values()
+	total := 0
+
+	for day := start; !day.After(end); {
+		last := day.AddDate(0, 0, window-1)
+		if last.After(end) {
+			last = end
+		}
+
+		num, err := m.exportWindow(ctx, day, last, output, &args)
+		total += num
+
+		if err != nil && (num > 0 || ctx.Err() != nil || !tooLarge(err)) {
+			return total, err
+		} else if err != nil && window > 1 {
+			window /= 2
+
+			m.logf("%s: %s: request failed, retrying %d days at a time: %s",
+				m.Product, formatSpan(day, last), window, err)
+			continue
+		} else if err != nil {
+			m.logf("%s: %s: request failed, splitting day into hours: %s",
+				m.Product, formatSpan(day, last), err)
+
+			if num, err = m.exportHours(ctx, day, output, query); err != nil {
+				return total + num, err
+			}
+			total += num
+		}
+
+		m.logf("%s: %s: %d records.", m.Product, formatSpan(day, last), num)
+
+		day = last.AddDate(0, 0, 1)
+	}
+
+	return total, nil
+}
+
+// exportHours exports a single day in chunks of hours, halving the chunk
+// size every time a request fails without producing records, down to a
+// single hour. Chunks are narrowed down to `query.Since` and `query.Until`,
+// and skipped entirely if they lie outside of them.
+func (m *Mixpanel) exportHours(ctx context.Context, day time.Time, output chan<- EventData, query ExportOptions) (int, error) {
+	since, until := query.Since, query.Until
+
+	step := 12 * time.Hour
+	next := day.AddDate(0, 0, 1)
+	total := 0
+
+	for from := day; from.Before(next); {
+		to := from.Add(step)
+		if to.After(next) {
+			to = next
+		}
+
+		query.Since, query.Until = from, to
+		if since.After(from) {
+			query.Since = since
+		}
+		if !until.IsZero() && until.Before(to) {
+			query.Until = until
+		}
+
+		if query.Since.Before(query.Until) {
+			args := query.Values()
+
+			num, err := m.exportWindow(ctx, day, day, output, &args)
+			total += num
+
+			if err != nil && (num > 0 || ctx.Err() != nil || !tooLarge(err) || step <= time.Hour) {
+				return total, err
+			} else if err != nil {
+				if step /= 2; step < time.Hour {
+					step = time.Hour
+				}
+				continue
+			}
+		}
+
+		from = to
+	}
+
+	return total, nil
+}
+
+// formatSpan renders a range of days for log messages.
+func formatSpan(from, to time.Time) string {
+	if from.Equal(to) {
+		return from.Format("2006-01-02")
+	}
+
+	return from.Format("2006-01-02") + " to " + to.Format("2006-01-02")
+}
@@ -0,0 +1,200 @@
+package mixpanel
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rangeServer serves one record per requested day, refusing any request
+// spanning more than `maxDays` days. Requests for a whole day listed in
+// `hugeDays` are refused as well, only chunks of hours are served.
+func rangeServer(maxDays int, hugeDays ...string) (*httptest.Server, *[]string) {
+	var requests []string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		args := r.URL.Query()
+		from, _ := time.Parse("2006-01-02", args.Get("from_date"))
+		to, _ := time.Parse("2006-01-02", args.Get("to_date"))
+		where := args.Get("where")
+
+		requests = append(requests, fmt.Sprintf("%s %s %s", args.Get("from_date"), args.Get("to_date"), where))
+
+		days := int(to.Sub(from).Hours()/24) + 1
+		if days > maxDays {
+			fmt.Fprintln(w, `{"error": "too much data"}`)
+			return
+		}
+
+		for _, huge := range hugeDays {
+			if args.Get("from_date") == huge && where == "" {
+				fmt.Fprintln(w, `{"error": "too much data"}`)
+				return
+			}
+		}
+
+		for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+			fmt.Fprintf(w, `{"event": "e", "properties": {"day": "%s"}}`+"\n",
+				day.Format("2006-01-02"))
+		}
+	}))
+
+	return server, &requests
+}
+
+func TestExportRangeSingleRequest(t *testing.T) {
+	server, requests := rangeServer(30)
+	defer server.Close()
+
+	mix := NewWithURL("product", "key", "secret", server.URL)
+	mix.MaxWindow = 30
+
+	start, _ := time.Parse("2006-01-02", "2014-01-01")
+	end := start.AddDate(0, 0, 9)
+
+	output := make(chan EventData, 100)
+
+	if num, err := mix.ExportRange(context.Background(), start, end, output, nil); err != nil {
+		t.Fatalf("raised error: %v", err)
+	} else if num != 10 {
+		t.Errorf("expected 10 records, got %d", num)
+	}
+
+	if len(*requests) != 1 {
+		t.Errorf("expected a single request, got %v", *requests)
+	}
+}
+
+func TestExportRangeShrinksWindow(t *testing.T) {
+	server, requests := rangeServer(2)
+	defer server.Close()
+
+	mix := NewWithURL("product", "key", "secret", server.URL)
+	mix.MaxWindow = 8
+
+	start, _ := time.Parse("2006-01-02", "2014-01-01")
+	end := start.AddDate(0, 0, 5)
+
+	output := make(chan EventData, 100)
+
+	if num, err := mix.ExportRange(context.Background(), start, end, output, nil); err != nil {
+		t.Fatalf("raised error: %v", err)
+	} else if num != 6 {
+		t.Errorf("expected 6 records, got %d", num)
+	}
+	close(output)
+
+	// 8 days fails, 4 days fails, then three requests of 2 days.
+	if len(*requests) != 5 {
+		t.Errorf("expected 5 requests, got %v", *requests)
+	}
+
+	seen := make(map[string]bool)
+	for ev := range output {
+		seen[ev["day"].(string)] = true
+	}
+
+	if len(seen) != 6 {
+		t.Errorf("expected 6 distinct days, got %v", seen)
+	}
+}
+
+func TestExportRangeSplitsHours(t *testing.T) {
+	server, requests := rangeServer(1, "2014-01-02")
+	defer server.Close()
+
+	mix := NewWithURL("product", "key", "secret", server.URL)
+
+	start, _ := time.Parse("2006-01-02", "2014-01-01")
+	end := start.AddDate(0, 0, 2)
+
+	output := make(chan EventData, 100)
+
+	// Two whole days, plus 2 chunks of 12 hours for the huge day.
+	if num, err := mix.ExportRange(context.Background(), start, end, output, nil); err != nil {
+		t.Fatalf("raised error: %v", err)
+	} else if num != 4 {
+		t.Errorf("expected 4 records, got %d", num)
+	}
+
+	hourly := 0
+	for _, req := range *requests {
+		if strings.Contains(req, `properties["time"] >= datetime(`) {
+			hourly++
+		}
+	}
+
+	if hourly != 2 {
+		t.Errorf("expected 2 requests for hour chunks, got %v", *requests)
+	}
+}
+
+func TestExportRangeParseError(t *testing.T) {
+	requests := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		fmt.Fprintln(w, `{"event": "e", "properties": {`)
+	}))
+	defer server.Close()
+
+	mix := NewWithURL("product", "key", "secret", server.URL)
+	mix.MaxWindow = 4
+
+	start, _ := time.Parse("2006-01-02", "2014-01-01")
+
+	_, err := mix.ExportRange(context.Background(), start, start.AddDate(0, 0, 3), make(chan EventData, 1), nil)
+	if _, ok := err.(*ParseError); !ok {
+		t.Errorf("expected a ParseError, got %v", err)
+	}
+
+	if requests != 1 {
+		t.Errorf("expected the range not to be split, saw %d requests", requests)
+	}
+}
+
+func TestExportRangeSinceUntil(t *testing.T) {
+	var requests []string
+
+	// Only serve requests with an upper bound on `time`, i.e. hour chunks.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		where := r.URL.Query().Get("where")
+		requests = append(requests, where)
+
+		if !strings.Contains(where, `properties["time"] < datetime(`) {
+			fmt.Fprintln(w, `{"error": "too much data"}`)
+			return
+		}
+
+		fmt.Fprintln(w, `{"event": "e", "properties": {}}`)
+	}))
+	defer server.Close()
+
+	mix := NewWithURL("product", "key", "secret", server.URL)
+
+	day, _ := time.Parse("2006-01-02", "2014-01-01")
+	opts := ExportOptions{Where: `properties["a"] == 1`, Since: day.Add(13 * time.Hour)}
+
+	if num, err := mix.ExportRange(context.Background(), day, day, make(chan EventData, 10), &opts); err != nil {
+		t.Fatalf("raised error: %v", err)
+	} else if num != 1 {
+		t.Errorf("expected 1 record, got %d", num)
+	}
+
+	// The whole day, then only the second half of it: the first one lies
+	// entirely before `Since`.
+	expected := []string{
+		fmt.Sprintf(`(properties["a"] == 1) and properties["time"] >= datetime(%d)`,
+			opts.Since.Unix()),
+		fmt.Sprintf(`(properties["a"] == 1) and properties["time"] >= datetime(%d) and properties["time"] < datetime(%d)`,
+			opts.Since.Unix(), day.AddDate(0, 0, 1).Unix()),
+	}
+
+	if fmt.Sprint(requests) != fmt.Sprint(expected) {
+		t.Errorf("unexpected requests: %q", requests)
+	}
+}
@@ -0,0 +1,542 @@
+package mixpanel
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// EventReader reads raw export records from an underlying reader one at a
+// time, transforming them the same way TransformEventData does.
+//
+// This is a pull-based alternative to the channel API:
+//
+//	r, err := client.OpenDate(ctx, date, nil)
+//	if err != nil { ... }
+//	defer r.Close()
+//
+//	for {
+//		ev, err := r.Next()
+//		if err == io.EOF {
+//			break
+//		} else if err != nil { ... }
+//		...
+//	}
+type EventReader struct {
+	m     *Mixpanel
+	ctx   context.Context
+	input io.Reader
+	lines *bufio.Reader
+	count int
+
+	// The number of lines read from the input so far, and the last one.
+	line    int
+	current []byte
+
+	// Records rejected according to `m.Rejects`, and how many of those
+	// returned have been added to the client's totals.
+	rejects, tallied int
+
+	// Whether the input may contain records exported by mixport itself,
+	// see NewDumpReader.
+	dump bool
+
+	// Only set for API responses, which can be requested again, see
+	// openWindow.
+	reopen   func() (io.Reader, error)
+	expected func() (int, error)
+	resumes  int
+
+	// The last record decoded, and the line it's on.
+	last     recordMark
+	lastLine int
+}
+
+// rawEvent is a record as it comes out of the export API.
+type rawEvent struct {
+	Error      *string
+	Event      string
+	Properties map[string]interface{}
+
+	// The record's original bytes, only kept for RawRecords.
+	raw json.RawMessage
+
+	// The ID the record was previously exported with, if any.
+	id string
+}
+
+// recordMark identifies a record well enough to tell whether a resumed
+// response lines up with the one it replaces.
+//
+// `time` and `$insert_id` may hold any JSON value, including arrays and
+// objects that can't be compared with ==, so their encoding is kept instead.
+type recordMark struct {
+	event          string
+	time, insertID string
+}
+
+func markOf(ev *rawEvent) recordMark {
+	// Can't fail for decoded JSON, and object keys come out sorted.
+	t, _ := json.Marshal(ev.Properties["time"])
+	id, _ := json.Marshal(ev.Properties["$insert_id"])
+
+	return recordMark{ev.Event, string(t), string(id)}
+}
+
+// truncatedError reports a response that ended before all of its records
+// were received.
+type truncatedError struct {
+	cause error
+}
+
+func (e truncatedError) Error() string {
+	return fmt.Sprintf("response truncated (%s)", e.cause)
+}
+
+// CountFunc returns the number of records an export of the days from `from`
+// to `to` with the given arguments is expected to yield. See
+// `Mixpanel.ExpectedCount`.
+type CountFunc func(ctx context.Context, from, to time.Time, args url.Values) (int, error)
+
+// NewEventReader creates an EventReader transforming the JSON records read
+// from `input`.
+func (m *Mixpanel) NewEventReader(input io.Reader) *EventReader {
+	return m.newEventReader(context.Background(), input)
+}
+
+// NewDumpReader creates an EventReader over a local dump of event data,
+// which may contain both records as returned by the export API and JSON
+// records written by mixport in any TransformMode. The latter are turned
+// back into the former before being transformed again, keeping the IDs they
+// were exported with.
+func (m *Mixpanel) NewDumpReader(input io.Reader) *EventReader {
+	r := m.newEventReader(context.Background(), input)
+	r.dump = true
+
+	return r
+}
+
+// newEventReader creates an EventReader that reports errors caused by `ctx`
+// being cancelled as such.
+func (m *Mixpanel) newEventReader(ctx context.Context, input io.Reader) *EventReader {
+	r := &EventReader{m: m, ctx: ctx}
+	r.setInput(input)
+
+	return r
+}
+
+func (r *EventReader) setInput(input io.Reader) {
+	r.input = input
+	r.lines = bufio.NewReader(input)
+	r.line = 0
+}
+
+// OpenDate starts downloading the event data for the given day and returns
+// an EventReader over the response. See ExportDate for the meaning of
+// `moreArgs`. The caller has to Close the reader when done with it.
+//
+// If the response turns out to be truncated, the day is requested again (up
+// to `m.Retry.Retries` times) and the records already returned are skipped,
+// so that every record is returned exactly once.
+//
+// Cancelling `ctx` aborts the download.
+func (m *Mixpanel) OpenDate(ctx context.Context, date time.Time, moreArgs *url.Values) (*EventReader, error) {
+	return m.openWindow(ctx, date, date, moreArgs)
+}
+
+// openWindow does the actual work of OpenDate for an arbitrary span of days.
+func (m *Mixpanel) openWindow(ctx context.Context, from, to time.Time, moreArgs *url.Values) (*EventReader, error) {
+	args := m.makeArgs(from, to)
+
+	if moreArgs != nil {
+		for k, vs := range *moreArgs {
+			for _, v := range vs {
+				args.Add(k, v)
+			}
+		}
+	}
+
+	open := func() (io.Reader, error) {
+		resp, err := m.get(ctx, m.BaseURL, args)
+		if err != nil {
+			return nil, m.failed("download", err)
+		}
+
+		return resp.Body, nil
+	}
+
+	body, err := open()
+	if err != nil {
+		return nil, err
+	}
+
+	r := &EventReader{m: m, ctx: ctx, reopen: open}
+	r.setInput(body)
+
+	if m.ExpectedCount != nil {
+		r.expected = func() (int, error) {
+			return m.ExpectedCount(ctx, from, to, args)
+		}
+	}
+
+	return r, nil
+}
+
+// Next returns the next transformed record, or io.EOF once the input is
+// exhausted. Any other error is final.
+func (r *EventReader) Next() (EventData, error) {
+	m := r.m
+
+	for {
+		ev, err := r.decode()
+
+		if err == io.EOF {
+			if err = r.reconcile(); err == nil {
+				accept(r.input)
+
+				if err = r.tally(); err == nil {
+					return nil, io.EOF
+				}
+			}
+		}
+
+		if cause, ok := err.(truncatedError); ok {
+			if err = r.resume(cause); err == nil {
+				continue
+			}
+		}
+
+		if _, ok := err.(*ParseError); ok && m.Rejects.Output != nil {
+			if err = r.reject(err); err == nil {
+				continue
+			}
+		}
+
+		// An error object is a complete response too, and one worth
+		// replaying, see Mixpanel.RecordDir.
+		if _, ok := err.(*APIError); ok {
+			accept(r.input)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		r.last, r.lastLine = markOf(ev), r.line
+
+		id := ev.id
+		if id == "" {
+			if id, err = m.eventID(ev.Event, ev.Properties); err != nil {
+				return nil, fmt.Errorf("%s: generating UUID failed: %s", m.Product, err)
+			}
+		}
+
+		utc, local, err := m.timestamps(ev.Properties)
+		if err != nil {
+			err = fmt.Errorf("%s: converting Timestamp failed: %s", m.Product, err)
+
+			if m.Rejects.Output != nil {
+				if err = r.reject(err); err == nil {
+					continue
+				}
+			}
+
+			return nil, err
+		}
+
+		record, err := m.record(ev, id, utc, local)
+		if err != nil {
+			return nil, err
+		}
+
+		r.count++
+
+		return record, nil
+	}
+}
+
+// decode reads the next record from the input without transforming it.
+// Records are expected one per line, blank lines are skipped.
+func (r *EventReader) decode() (*rawEvent, error) {
+	for {
+		line, err := r.readLine()
+		if err != nil {
+			return nil, err
+		}
+
+		if len(line) > 0 {
+			return r.parse(line)
+		}
+	}
+}
+
+// readLine reads the next line from the input, stripped of surrounding
+// whitespace.
+//
+// For API responses, a body cut short by the connection (a Content-Length
+// or chunked encoding that isn't satisfied, a read error, or a last record
+// that isn't terminated by a newline) is reported as a truncatedError.
+func (r *EventReader) readLine() ([]byte, error) {
+	line, err := r.lines.ReadBytes('\n')
+
+	switch {
+	case err == nil:
+	case err == io.EOF && len(bytes.TrimSpace(line)) == 0:
+		return nil, io.EOF
+	case err == io.EOF && r.reopen != nil:
+		return nil, truncatedError{errors.New("missing trailing newline")}
+	case err == io.EOF:
+		// The last line of local input may go without one.
+	case r.ctx.Err() != nil:
+		// Reads from a cancelled request fail with a rather
+		// confusing error, report the cancellation instead.
+		return nil, r.ctx.Err()
+	case r.reopen != nil:
+		return nil, truncatedError{err}
+	default:
+		return nil, fmt.Errorf("%s: reading input failed: %s", r.m.Product, err)
+	}
+
+	r.line++
+	r.current = bytes.TrimSpace(line)
+
+	return r.current, nil
+}
+
+// parse decodes a single line of input.
+func (r *EventReader) parse(line []byte) (*rawEvent, error) {
+	m := r.m
+
+	var (
+		ev  rawEvent
+		err error
+	)
+
+	switch {
+	case r.dump:
+		err = parseExported(line, &ev)
+	case m.Transform == RawRecords:
+		ev.raw = line
+		err = decodeNumbers(line, &ev)
+	default:
+		err = decodeNumbers(line, &ev)
+	}
+
+	if err != nil {
+		return nil, &ParseError{Product: m.Product, Line: r.line, Data: line, Err: err}
+	} else if ev.Error != nil {
+		return nil, &APIError{Product: m.Product, StatusCode: http.StatusOK, Body: *ev.Error}
+	}
+
+	if ev.Properties == nil {
+		ev.Properties = make(map[string]interface{})
+	}
+
+	return &ev, nil
+}
+
+// decodeNumbers decodes a single JSON value, keeping numbers as json.Number
+// rather than defaulting them all to float.
+func decodeNumbers(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	if err := decoder.Decode(v); err != nil {
+		return err
+	} else if decoder.More() {
+		return errors.New("unexpected data after the record")
+	}
+
+	return nil
+}
+
+// exportedKeys are the keys added to flattened records by the client, other
+// than the event's properties.
+var exportedKeys = []string{EventIDKey, TimestampKey, LocalTimestampKey, "product", "event"}
+
+// parseExported parses a record that may have been exported by mixport
+// before, see NewDumpReader.
+//
+// Flattened records are told apart by having an "event" but no "properties".
+// For records exported before, `ev.raw` is rebuilt from the event and its
+// properties, since the original bytes are lost.
+func parseExported(raw json.RawMessage, ev *rawEvent) error {
+	ev.raw = raw
+
+	record := make(map[string]interface{})
+	if err := decodeNumbers(raw, &record); err != nil {
+		return err
+	}
+
+	// Errors from the API have neither.
+	if _, ok := record["properties"]; ok || record["event"] == nil {
+		if err := decodeNumbers(raw, ev); err != nil {
+			return err
+		}
+
+		meta, ok := record[MetadataKey].(map[string]interface{})
+		if !ok {
+			return nil
+		}
+
+		ev.id, _ = meta["event_id"].(string)
+	} else {
+		ev.Event, _ = record["event"].(string)
+		ev.id, _ = record[EventIDKey].(string)
+
+		for _, key := range exportedKeys {
+			delete(record, key)
+		}
+
+		ev.Properties = record
+	}
+
+	var err error
+	ev.raw, err = json.Marshal(map[string]interface{}{
+		"event":      ev.Event,
+		"properties": ev.Properties,
+	})
+
+	return err
+}
+
+// reconcile compares the number of records received against
+// `Mixpanel.ExpectedCount`, if set, once the response has ended. Coming up
+// short is treated as truncation.
+func (r *EventReader) reconcile() error {
+	if r.expected == nil {
+		return nil
+	}
+
+	expected, err := r.expected()
+	if err != nil {
+		r.m.logf("%s: couldn't get expected record count: %s", r.m.Product, err)
+		return nil
+	}
+
+	// Rejected records were received all the same.
+	received := r.count + r.rejects
+
+	if received < expected {
+		return truncatedError{fmt.Errorf("got %d of %d records", received, expected)}
+	} else if received > expected {
+		r.m.logf("%s: got %d records, expected only %d", r.m.Product, received, expected)
+	}
+
+	return nil
+}
+
+// resume requests the data again after a truncated response, skipping over
+// the lines that have already been read. Fails if the retries are used up,
+// or the new response doesn't line up with the old one.
+func (r *EventReader) resume(cause truncatedError) error {
+	m := r.m
+	read := r.line
+
+	for {
+		if r.reopen == nil {
+			return fmt.Errorf("%s: %s", m.Product, cause)
+		} else if r.resumes >= m.Retry.Retries {
+			return fmt.Errorf("%s: %s after %d records, giving up", m.Product, cause, r.count)
+		}
+
+		r.resumes++
+
+		wait := m.Retry.backoff(r.resumes)
+		m.logf("%s: %s after %d records, resuming in %s", m.Product, cause, r.count, wait)
+
+		timer := time.NewTimer(wait)
+
+		select {
+		case <-timer.C:
+		case <-r.ctx.Done():
+			timer.Stop()
+			return r.ctx.Err()
+		}
+
+		r.Close()
+
+		input, err := r.reopen()
+		if err != nil {
+			return err
+		}
+
+		r.setInput(input)
+
+		if err := r.skip(read); err == nil {
+			return nil
+		} else if t, ok := err.(truncatedError); ok {
+			cause = t
+		} else {
+			return err
+		}
+	}
+}
+
+// skip reads past the first `lines` lines of the input, checking that the
+// last record decoded from them before matches. Lines aren't parsed
+// otherwise, so that rejected records don't get rejected again.
+func (r *EventReader) skip(lines int) error {
+	m := r.m
+
+	for r.line < lines {
+		line, err := r.readLine()
+
+		if err == io.EOF {
+			return fmt.Errorf("%s: resumed response has only %d of %d lines already received",
+				m.Product, r.line, lines)
+		} else if err != nil {
+			return err
+		}
+
+		if r.line != r.lastLine {
+			continue
+		}
+
+		if ev, err := r.parse(line); err != nil || markOf(ev) != r.last {
+			return fmt.Errorf("%s: resumed response doesn't match the truncated one", m.Product)
+		}
+	}
+
+	return nil
+}
+
+// send sends all remaining records on `output`, until the input is exhausted
+// or `ctx` is cancelled. Returns the number of records sent.
+func (r *EventReader) send(ctx context.Context, output chan<- EventData) (int, error) {
+	for sent := 0; ; sent++ {
+		ev, err := r.Next()
+		if err == io.EOF {
+			return sent, nil
+		} else if err != nil {
+			return sent, err
+		}
+
+		select {
+		case output <- ev:
+		case <-ctx.Done():
+			return sent, ctx.Err()
+		}
+	}
+}
+
+// Count returns the number of records returned by Next so far.
+func (r *EventReader) Count() int {
+	return r.count
+}
+
+// Close closes the underlying reader, if it's an io.Closer.
+func (r *EventReader) Close() error {
+	if closer, ok := r.input.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
@@ -0,0 +1,316 @@
+package mixpanel
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestEventReader(t *testing.T) {
+	mix := New("product", "", "")
+	input := strings.NewReader(`{"event": "a", "properties": {"time": 1}}
+{"event": "b"}
+`)
+
+	reader := mix.NewEventReader(input)
+	defer reader.Close()
+
+	for _, name := range []string{"a", "b"} {
+		ev, err := reader.Next()
+		if err != nil {
+			t.Fatalf("raised error: %v", err)
+		}
+
+		if ev["event"] != name || ev["product"] != "product" {
+			t.Errorf("unexpected record: %v", ev)
+		}
+	}
+
+	if _, err := reader.Next(); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+
+	if reader.Count() != 2 {
+		t.Errorf("expected 2 records, got %d", reader.Count())
+	}
+}
+
+func TestEventReaderApiError(t *testing.T) {
+	mix := New("product", "", "")
+	reader := mix.NewEventReader(strings.NewReader(`{"error": "some error"}`))
+
+	if _, err := reader.Next(); err == nil || err == io.EOF {
+		t.Errorf("expected an error, got %v", err)
+	}
+}
+
+func TestDumpReader(t *testing.T) {
+	mix := New("product", "", "")
+	mix.Collisions = FailCollisions
+
+	input := strings.NewReader(`{"event": "a", "properties": {"time": 1, "k": "v"}}
+{"$__$$event_id": "id-b", "$__$$timestamp": "1970-01-01 00:00:01", "event": "b", "product": "other", "time": 1, "k": "v"}
+{"event": "c", "properties": {"time": 1, "k": "v"}, "_mixport": {"event_id": "id-c", "product": "other"}}
+`)
+
+	reader := mix.NewDumpReader(input)
+
+	for _, name := range []string{"a", "b", "c"} {
+		ev, err := reader.Next()
+		if err != nil {
+			t.Fatalf("raised error: %v", err)
+		}
+
+		if ev["event"] != name || ev["product"] != "product" || ev["k"] != "v" {
+			t.Errorf("unexpected record: %v", ev)
+		}
+
+		if id := ev[EventIDKey]; name != "a" && id != "id-"+name {
+			t.Errorf("%s: expected ID to be kept, got %v", name, id)
+		}
+
+		if ev[TimestampKey] != "1970-01-01 00:00:01" {
+			t.Errorf("%s: bad timestamp: %v", name, ev[TimestampKey])
+		}
+	}
+
+	if _, err := reader.Next(); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+}
+
+func TestDumpReaderRaw(t *testing.T) {
+	mix := New("product", "", "")
+	mix.Transform = RawRecords
+
+	reader := mix.NewDumpReader(strings.NewReader(`{"$__$$event_id": "id", "event": "b", "product": "product", "k": "v"}`))
+
+	ev, err := reader.Next()
+	if err != nil {
+		t.Fatalf("raised error: %v", err)
+	}
+
+	if raw, _ := ev.Raw(); string(raw) != `{"event":"b","properties":{"k":"v"}}` {
+		t.Errorf("unexpected raw record: %s", raw)
+	}
+}
+
+func TestOpenDate(t *testing.T) {
+	closed := make(chan struct{})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"event": "e", "properties": {"day": "%s"}}`+"\n", r.URL.Query().Get("from_date"))
+		w.(http.Flusher).Flush()
+
+		// Keep the response open until the client goes away.
+		<-r.Context().Done()
+		close(closed)
+	}))
+	defer server.Close()
+
+	mix := NewWithURL("product", "key", "secret", server.URL)
+	date, _ := time.Parse("2006-01-02", "2014-01-01")
+
+	reader, err := mix.OpenDate(context.Background(), date, nil)
+	if err != nil {
+		t.Fatalf("raised error: %v", err)
+	}
+
+	ev, err := reader.Next()
+	if err != nil {
+		t.Fatalf("raised error: %v", err)
+	}
+
+	if ev["day"] != "2014-01-01" {
+		t.Errorf("unexpected record: %v", ev)
+	}
+
+	reader.Close()
+
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Error("expected Close to abort the download")
+	}
+}
+
+// truncatingServer serves four records, cutting the first `truncated`
+// responses short after two of them using `cut`.
+func truncatingServer(truncated int32, cut func(w http.ResponseWriter, records []string)) (*httptest.Server, *int32) {
+	var requests int32
+
+	records := []string{
+		`{"event": "e", "properties": {"time": 1, "$insert_id": "a"}}` + "\n",
+		`{"event": "e", "properties": {"time": 2, "$insert_id": "b"}}` + "\n",
+		`{"event": "e", "properties": {"time": 3, "$insert_id": "c"}}` + "\n",
+		`{"event": "e", "properties": {"time": 4, "$insert_id": "d"}}` + "\n",
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) <= truncated {
+			cut(w, records)
+			return
+		}
+
+		fmt.Fprint(w, strings.Join(records, ""))
+	}))
+
+	return server, &requests
+}
+
+// readDay reads all records of a day from the server, returning their
+// `$insert_id`s.
+func readDay(mix *Mixpanel) (string, error) {
+	date, _ := time.Parse("2006-01-02", "2014-01-01")
+
+	reader, err := mix.OpenDate(context.Background(), date, nil)
+	if err != nil {
+		return "", err
+	}
+
+	defer reader.Close()
+
+	var ids string
+
+	for {
+		ev, err := reader.Next()
+		if err == io.EOF {
+			return ids, nil
+		} else if err != nil {
+			return ids, err
+		}
+
+		ids += ev["$insert_id"].(string)
+	}
+}
+
+func TestResumeTruncated(t *testing.T) {
+	cuts := map[string]func(w http.ResponseWriter, records []string){
+		"content length": func(w http.ResponseWriter, records []string) {
+			w.Header().Set("Content-Length", "1000")
+			fmt.Fprint(w, records[0]+records[1])
+		},
+		"chunked": func(w http.ResponseWriter, records []string) {
+			fmt.Fprint(w, records[0]+records[1])
+			w.(http.Flusher).Flush()
+
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+		},
+		"trailing newline": func(w http.ResponseWriter, records []string) {
+			fmt.Fprint(w, records[0]+strings.TrimSpace(records[1]))
+		},
+		"partial record": func(w http.ResponseWriter, records []string) {
+			fmt.Fprint(w, records[0]+records[1]+records[2][:10])
+		},
+	}
+
+	for name, cut := range cuts {
+		server, requests := truncatingServer(2, cut)
+
+		mix := NewWithURL("product", "key", "secret", server.URL)
+		mix.Retry = RetryPolicy{Retries: 2, MinWait: time.Millisecond}
+
+		ids, err := readDay(mix)
+		server.Close()
+
+		if err != nil {
+			t.Errorf("%s: raised error: %v", name, err)
+		} else if ids != "abcd" {
+			t.Errorf("%s: expected each record once, got %s", name, ids)
+		}
+
+		if *requests != 3 {
+			t.Errorf("%s: expected 3 requests, got %d", name, *requests)
+		}
+	}
+}
+
+func TestResumeUncomparableMark(t *testing.T) {
+	var requests int32
+
+	records := `{"event": "e", "properties": {"time": [1], "$insert_id": {"x": 1, "y": 2}}}
+{"event": "e", "properties": {"time": 2, "$insert_id": "b"}}
+`
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			// Cut short partway into the second record.
+			fmt.Fprint(w, records[:strings.Index(records, "\n")+10])
+			return
+		}
+
+		fmt.Fprint(w, records)
+	}))
+	defer server.Close()
+
+	mix := NewWithURL("product", "key", "secret", server.URL)
+	mix.Retry = RetryPolicy{Retries: 1, MinWait: time.Millisecond}
+
+	date, _ := time.Parse("2006-01-02", "2014-01-01")
+	output := make(chan EventData, 2)
+
+	if num, err := mix.ExportDate(date, output, nil); err != nil {
+		t.Errorf("raised error: %v", err)
+	} else if num != 2 {
+		t.Errorf("expected 2 records, got %d", num)
+	}
+}
+
+func TestResumeGivesUp(t *testing.T) {
+	server, _ := truncatingServer(10, func(w http.ResponseWriter, records []string) {
+		fmt.Fprint(w, strings.TrimSpace(records[0]))
+	})
+	defer server.Close()
+
+	mix := NewWithURL("product", "key", "secret", server.URL)
+	mix.Retry = RetryPolicy{Retries: 1, MinWait: time.Millisecond}
+
+	if _, err := readDay(mix); err == nil || !strings.Contains(err.Error(), "truncated") {
+		t.Errorf("expected truncation error, got %v", err)
+	}
+}
+
+func TestResumeMismatch(t *testing.T) {
+	server, _ := truncatingServer(1, func(w http.ResponseWriter, records []string) {
+		fmt.Fprint(w, records[1]+strings.TrimSpace(records[0]))
+	})
+	defer server.Close()
+
+	mix := NewWithURL("product", "key", "secret", server.URL)
+	mix.Retry = RetryPolicy{Retries: 1, MinWait: time.Millisecond}
+
+	if _, err := readDay(mix); err == nil || !strings.Contains(err.Error(), "doesn't match") {
+		t.Errorf("expected mismatch error, got %v", err)
+	}
+}
+
+func TestExpectedCount(t *testing.T) {
+	server, requests := truncatingServer(1, func(w http.ResponseWriter, records []string) {
+		fmt.Fprint(w, records[0]+records[1])
+	})
+	defer server.Close()
+
+	mix := NewWithURL("product", "key", "secret", server.URL)
+	mix.Retry = RetryPolicy{Retries: 1, MinWait: time.Millisecond}
+	mix.ExpectedCount = func(ctx context.Context, from, to time.Time, args url.Values) (int, error) {
+		return 4, nil
+	}
+
+	if ids, err := readDay(mix); err != nil {
+		t.Errorf("raised error: %v", err)
+	} else if ids != "abcd" {
+		t.Errorf("expected each record once, got %s", ids)
+	}
+
+	if *requests != 2 {
+		t.Errorf("expected 2 requests, got %d", *requests)
+	}
+}
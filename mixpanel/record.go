@@ -0,0 +1,213 @@
+package mixpanel
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Request arguments that only carry credentials, and so don't identify a
+// recorded response.
+var authArgs = []string{"api_key", "expire", "sig", "project_id"}
+
+// recordingName returns the path of the file a response to `req` is recorded
+// in under `dir`: PRODUCT/ENDPOINT[-FROM-TO]-HASH.json, where HASH covers all
+// of the request's arguments apart from credentials.
+func (m *Mixpanel) recordingName(dir string, req *http.Request) string {
+	args := req.URL.Query()
+	for _, arg := range authArgs {
+		args.Del(arg)
+	}
+
+	hash := fnv.New64a()
+	io.WriteString(hash, req.URL.Path+"?"+args.Encode())
+
+	// Base URLs don't need to have a path at all.
+	name := path.Base(req.URL.Path)
+	if name == "." || name == "/" {
+		name = "export"
+	}
+
+	if from, to := args.Get("from_date"), args.Get("to_date"); from != "" && to != "" {
+		name += fmt.Sprintf("-%s-%s", from, to)
+	}
+
+	name += fmt.Sprintf("-%016x.json", hash.Sum64())
+
+	return filepath.Join(dir, m.Product, name)
+}
+
+// errorRecordingName returns the path of the file a response to a request
+// with a status other than 200 OK is recorded in, given the path of the file
+// the body of a successful one would be recorded in.
+func errorRecordingName(name string) string {
+	return strings.TrimSuffix(name, ".json") + ".error"
+}
+
+// replayResponse serves a request from the response recorded in
+// `m.ReplayDir`.
+func (m *Mixpanel) replayResponse(req *http.Request) (*http.Response, error) {
+	name := m.recordingName(m.ReplayDir, req)
+
+	fp, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return m.replayError(req, errorRecordingName(name))
+	} else if err != nil {
+		return nil, fmt.Errorf("no recorded response: %s", err)
+	}
+
+	return &http.Response{
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Body:       fp,
+		Request:    req,
+	}, nil
+}
+
+// replayError serves a request from the error response recorded in `name`,
+// see recordError.
+func (m *Mixpanel) replayError(req *http.Request, name string) (*http.Response, error) {
+	fp, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("no recorded response: %s", err)
+	}
+
+	defer fp.Close()
+
+	resp, err := http.ReadResponse(bufio.NewReader(fp), req)
+	if err != nil {
+		return nil, fmt.Errorf("bad recorded response %s: %s", name, err)
+	}
+
+	// The body is small, see recordError, and has to outlive the file.
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("bad recorded response %s: %s", name, err)
+	}
+
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	return resp, nil
+}
+
+// recordResponse records a response in a file in `m.RecordDir`, replacing
+// whatever was recorded for the same request before.
+//
+// The body of a successful response is teed into the file as it's read. The
+// file only appears once the body has been accepted as complete by whoever
+// reads it (see `accept`), so incomplete responses are never recorded. Other
+// responses are recorded by recordError.
+func (m *Mixpanel) recordResponse(req *http.Request, resp *http.Response) error {
+	name := m.recordingName(m.RecordDir, req)
+
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return fmt.Errorf("couldn't create recording directory: %s", err)
+	}
+
+	fp, err := ioutil.TempFile(filepath.Dir(name), ".recording-")
+	if err != nil {
+		return fmt.Errorf("couldn't create recording: %s", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return recordError(resp, fp, errorRecordingName(name), name)
+	}
+
+	resp.Body = &recordingBody{ReadCloser: resp.Body, file: fp, name: name}
+
+	return nil
+}
+
+// recordError writes a response with a status other than 200 OK, headers
+// and all, to `fp` and moves it to `name`, removing the recording of a
+// successful response at `stale`. Only as much of the body is kept as makes
+// it into an APIError.
+func recordError(resp *http.Response, fp *os.File, name, stale string) error {
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	if err != nil {
+		fp.Close()
+		os.Remove(fp.Name())
+		return err
+	}
+
+	saved := *resp
+	saved.Body = ioutil.NopCloser(bytes.NewReader(body))
+	saved.ContentLength = int64(len(body))
+	saved.TransferEncoding = nil
+
+	err = saved.Write(fp)
+	if cerr := fp.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		os.Remove(fp.Name())
+		return fmt.Errorf("couldn't write recording: %s", err)
+	}
+
+	os.Remove(stale)
+
+	return os.Rename(fp.Name(), name)
+}
+
+// recordingBody copies everything read from a response body to a temporary
+// file, which is moved into place on Close if the body has been accepted.
+type recordingBody struct {
+	io.ReadCloser
+	file     *os.File
+	name     string
+	accepted bool
+	err      error
+}
+
+// accept marks a response body as received in full, so that it's kept if
+// it's being recorded. Reaching io.EOF isn't enough, since a connection
+// closed early looks just the same to the reader. Anything not read yet is
+// recorded all the same.
+func accept(body io.Reader) {
+	if r, ok := body.(*recordingBody); ok {
+		if _, err := io.Copy(ioutil.Discard, r); err == nil {
+			r.accepted = true
+		}
+	}
+}
+
+func (r *recordingBody) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+
+	if n > 0 && r.err == nil {
+		_, r.err = r.file.Write(p[:n])
+	}
+
+	return n, err
+}
+
+func (r *recordingBody) Close() error {
+	err := r.ReadCloser.Close()
+
+	r.file.Close()
+
+	if r.accepted && r.err == nil {
+		os.Remove(errorRecordingName(r.name))
+		os.Rename(r.file.Name(), r.name)
+	} else {
+		os.Remove(r.file.Name())
+	}
+
+	return err
+}
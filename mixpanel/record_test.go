@@ -0,0 +1,213 @@
+package mixpanel
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecordingName(t *testing.T) {
+	mix := New("product", "key", "secret")
+
+	for _, base := range []string{"http://example.com", "http://example.com/"} {
+		req, _ := http.NewRequest("GET", base+"?from_date=2014-01-01&to_date=2014-01-02&api_key=key", nil)
+		name := mix.recordingName("dir", req)
+
+		if dir, file := filepath.Split(name); dir != filepath.Join("dir", "product")+string(filepath.Separator) ||
+			!strings.HasPrefix(file, "export-2014-01-01-2014-01-02-") {
+			t.Errorf("%s: bad recording name %s", base, name)
+		}
+	}
+}
+
+func TestRecordReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mixport-record-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"event": "e", "properties": {"day": "%s"}}`+"\n", r.URL.Query().Get("from_date"))
+	}))
+
+	date, _ := time.Parse("2006-01-02", "2014-01-01")
+
+	mix := NewWithURL("product", "key", "secret", server.URL+"/export")
+	mix.RecordDir = dir
+
+	output := make(chan EventData, 1)
+	if _, err := mix.ExportDate(date, output, nil); err != nil {
+		t.Fatalf("raised error: %v", err)
+	}
+	<-output
+
+	server.Close()
+
+	files, _ := filepath.Glob(filepath.Join(dir, "product", "*"))
+	if len(files) != 1 || !strings.HasPrefix(filepath.Base(files[0]), "export-2014-01-01-2014-01-01-") {
+		t.Errorf("expected a single recording, got %v", files)
+	}
+
+	// Signatures differ between requests, which mustn't matter.
+	mix = NewWithURL("product", "key", "secret", server.URL+"/export")
+	mix.ReplayDir = dir
+
+	if _, err := mix.ExportDate(date, output, nil); err != nil {
+		t.Fatalf("raised error: %v", err)
+	}
+
+	if ev := <-output; ev["day"] != "2014-01-01" {
+		t.Errorf("bad replayed record: %v", ev)
+	}
+
+	if _, err := mix.ExportDate(date.AddDate(0, 0, 1), output, nil); err == nil {
+		t.Error("expected error for a request that wasn't recorded")
+	}
+}
+
+func TestRecordIncomplete(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mixport-record-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "1000")
+		fmt.Fprintln(w, `{"event": "e", "properties": {}}`)
+	}))
+	defer server.Close()
+
+	mix := NewWithURL("product", "key", "secret", server.URL)
+	mix.RecordDir = dir
+	mix.Retry.Retries = 0
+
+	date, _ := time.Parse("2006-01-02", "2014-01-01")
+
+	if _, err := mix.ExportDate(date, make(chan EventData, 1), nil); err == nil {
+		t.Error("expected truncated response to fail")
+	}
+
+	if files, _ := filepath.Glob(filepath.Join(dir, "product", "*")); len(files) != 0 {
+		t.Errorf("expected nothing to be recorded, got %v", files)
+	}
+}
+
+func TestRecordMissingNewline(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mixport-record-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Ends in io.EOF all the same, but the last record is cut short.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"event": "e", "properties": {}}`)
+	}))
+	defer server.Close()
+
+	mix := NewWithURL("product", "key", "secret", server.URL)
+	mix.RecordDir = dir
+	mix.Retry.Retries = 0
+
+	date, _ := time.Parse("2006-01-02", "2014-01-01")
+
+	if _, err := mix.ExportDate(date, make(chan EventData, 1), nil); err == nil {
+		t.Error("expected truncated response to fail")
+	}
+
+	if files, _ := filepath.Glob(filepath.Join(dir, "product", "*")); len(files) != 0 {
+		t.Errorf("expected nothing to be recorded, got %v", files)
+	}
+}
+
+func TestRecordReplayShrinkingRange(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mixport-record-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server, requests := rangeServer(2)
+
+	start, _ := time.Parse("2006-01-02", "2014-01-01")
+	end := start.AddDate(0, 0, 5)
+
+	export := func(mix *Mixpanel) map[string]bool {
+		mix.MaxWindow = 8
+
+		output := make(chan EventData, 10)
+
+		if num, err := mix.ExportRange(context.Background(), start, end, output, nil); err != nil {
+			t.Fatalf("raised error: %v", err)
+		} else if num != 6 {
+			t.Errorf("expected 6 records, got %d", num)
+		}
+		close(output)
+
+		days := make(map[string]bool)
+		for ev := range output {
+			days[ev["day"].(string)] = true
+		}
+
+		return days
+	}
+
+	mix := NewWithURL("product", "key", "secret", server.URL)
+	mix.RecordDir = dir
+	recorded := export(mix)
+
+	server.Close()
+
+	// Both refused windows have to be refused again.
+	mix = NewWithURL("product", "key", "secret", server.URL)
+	mix.ReplayDir = dir
+
+	if replayed := export(mix); !reflect.DeepEqual(replayed, recorded) {
+		t.Errorf("expected %v, replayed %v", recorded, replayed)
+	}
+
+	if len(*requests) != 5 {
+		t.Errorf("expected only the 5 recorded requests, got %v", *requests)
+	}
+}
+
+func TestRecordReplayStatus(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mixport-record-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"error": "Invalid API secret"}`)
+	}))
+
+	date, _ := time.Parse("2006-01-02", "2014-01-01")
+
+	for _, replay := range []bool{false, true} {
+		mix := NewWithURL("product", "key", "secret", server.URL)
+		if replay {
+			mix.ReplayDir = dir
+		} else {
+			mix.RecordDir = dir
+		}
+
+		_, err := mix.ExportDate(date, make(chan EventData, 1), nil)
+		if apiErr, ok := err.(*APIError); !ok || apiErr.Err != ErrUnauthorized || apiErr.Body != "Invalid API secret" {
+			t.Errorf("replay=%t: expected ErrUnauthorized, got %v", replay, err)
+		}
+
+		server.Close()
+	}
+}
@@ -0,0 +1,80 @@
+package mixpanel
+
+import (
+	"fmt"
+	"io"
+)
+
+// RejectPolicy lets an export skip over malformed records instead of failing
+// on the first one. A record is malformed if it isn't valid JSON, or its
+// `time` can't be converted.
+//
+// - `Output`, if not nil, enables skipping. Each rejected record is written
+//   to it as a line with the error, prefixed with "# ", followed by a line
+//   with the record exactly as it was received.
+// - `MaxRejects`, if positive, fails the export once more records than that
+//   have been rejected.
+// - `MaxRatio`, if positive, fails the export once the rejected records make
+//   up more than that fraction of all records received by the client. This
+//   is checked whenever a response has been read completely.
+type RejectPolicy struct {
+	Output     io.Writer
+	MaxRejects int
+	MaxRatio   float64
+}
+
+// reject writes the line last read by the reader to `m.Rejects.Output`,
+// failing if that's one record too many.
+func (r *EventReader) reject(cause error) error {
+	m := r.m
+
+	m.rejectsMu.Lock()
+	defer m.rejectsMu.Unlock()
+
+	r.rejects++
+	m.rejected++
+
+	if _, err := fmt.Fprintf(m.Rejects.Output, "# %s\n%s\n", cause, r.current); err != nil {
+		return fmt.Errorf("%s: couldn't write rejected record: %s", m.Product, err)
+	}
+
+	if max := m.Rejects.MaxRejects; max > 0 && m.rejected > max {
+		return fmt.Errorf("%s: more than %d records rejected, giving up (%s)", m.Product, max, cause)
+	}
+
+	return nil
+}
+
+// tally adds the records returned by the reader to the client's total, and
+// checks the share of rejected records against `m.Rejects.MaxRatio`.
+func (r *EventReader) tally() error {
+	m := r.m
+
+	if m.Rejects.Output == nil {
+		return nil
+	}
+
+	m.rejectsMu.Lock()
+	defer m.rejectsMu.Unlock()
+
+	m.accepted += r.count - r.tallied
+	r.tallied = r.count
+
+	total := m.accepted + m.rejected
+
+	if ratio := m.Rejects.MaxRatio; ratio > 0 && float64(m.rejected) > ratio*float64(total) {
+		return fmt.Errorf("%s: %d of %d records rejected, more than the %g allowed",
+			m.Product, m.rejected, total, ratio)
+	}
+
+	return nil
+}
+
+// RejectCount returns the number of records the client has rejected so far,
+// see RejectPolicy.
+func (m *Mixpanel) RejectCount() int {
+	m.rejectsMu.Lock()
+	defer m.rejectsMu.Unlock()
+
+	return m.rejected
+}
@@ -0,0 +1,122 @@
+package mixpanel
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const rejectsInput = `{"event": "a", "properties": {"time": 1}}
+{"event": "b", "properties":
+{"event": "c", "properties": {"time": 1e999}}
+{"event": "d", "properties": {"time": 2}}
+`
+
+func TestRejects(t *testing.T) {
+	var rejects bytes.Buffer
+
+	mix := New("product", "", "")
+	mix.Rejects.Output = &rejects
+
+	output := make(chan EventData, 4)
+
+	if num, err := mix.TransformEventData(strings.NewReader(rejectsInput), output); err != nil {
+		t.Fatalf("raised error: %v", err)
+	} else if num != 2 {
+		t.Errorf("expected 2 records, got %d", num)
+	}
+
+	if ev := <-output; ev["event"] != "a" {
+		t.Errorf("unexpected record: %v", ev)
+	}
+
+	if ev := <-output; ev["event"] != "d" {
+		t.Errorf("unexpected record: %v", ev)
+	}
+
+	if mix.RejectCount() != 2 {
+		t.Errorf("expected 2 rejected records, got %d", mix.RejectCount())
+	}
+
+	lines := strings.Split(strings.TrimSpace(rejects.String()), "\n")
+
+	if len(lines) != 4 {
+		t.Fatalf("expected 4 lines of rejects, got %q", rejects.String())
+	}
+
+	if !strings.HasPrefix(lines[0], "# product: Failed to parse JSON on line 2: ") || lines[1] != `{"event": "b", "properties":` {
+		t.Errorf("bad rejected record: %q", lines[:2])
+	}
+
+	if !strings.HasPrefix(lines[2], "# product: converting Timestamp failed: ") || lines[3] != `{"event": "c", "properties": {"time": 1e999}}` {
+		t.Errorf("bad rejected record: %q", lines[2:])
+	}
+}
+
+func TestRejectsLimits(t *testing.T) {
+	cases := []struct {
+		MaxRejects int
+		MaxRatio   float64
+		OK         bool
+	}{
+		{0, 0, true},
+		{2, 0, true},
+		{1, 0, false},
+		{0, 0.5, true},
+		{0, 0.25, false},
+	}
+
+	for _, c := range cases {
+		var rejects bytes.Buffer
+
+		mix := New("product", "", "")
+		mix.Rejects = RejectPolicy{Output: &rejects, MaxRejects: c.MaxRejects, MaxRatio: c.MaxRatio}
+
+		_, err := mix.TransformEventData(strings.NewReader(rejectsInput), make(chan EventData, 4))
+
+		if c.OK && err != nil {
+			t.Errorf("%+v: raised error: %v", c, err)
+		} else if !c.OK && err == nil {
+			t.Errorf("%+v: expected error", c)
+		}
+	}
+}
+
+func TestResumeWithRejects(t *testing.T) {
+	records := `{"event": "e", "properties": {"$insert_id": "a"}}
+not json
+{"event": "e", "properties": {"$insert_id": "b"}}
+{"event": "e", "properties": {"$insert_id": "c"}}
+`
+	requests := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests++; requests == 1 {
+			fmt.Fprint(w, records[:len(records)-10])
+			return
+		}
+
+		fmt.Fprint(w, records)
+	}))
+	defer server.Close()
+
+	var rejects bytes.Buffer
+
+	mix := NewWithURL("product", "key", "secret", server.URL)
+	mix.Retry = RetryPolicy{Retries: 1, MinWait: time.Millisecond}
+	mix.Rejects.Output = &rejects
+
+	if ids, err := readDay(mix); err != nil {
+		t.Errorf("raised error: %v", err)
+	} else if ids != "abc" {
+		t.Errorf("expected each record once, got %s", ids)
+	}
+
+	if mix.RejectCount() != 1 || strings.Count(rejects.String(), "not json") != 1 {
+		t.Errorf("expected the bad line to be rejected once, got %q", rejects.String())
+	}
+}
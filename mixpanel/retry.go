@@ -0,0 +1,218 @@
+package mixpanel
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy describes how many times, and how patiently, a failed API
+// request is retried.
+//
+// - `Retries` is the number of additional attempts made after the first one
+//   fails. Zero disables retrying entirely.
+// - `MinWait` is the delay before the first retry. It doubles with each
+//   subsequent attempt, with some random jitter applied.
+// - `MaxWait` caps the delay between two attempts.
+type RetryPolicy struct {
+	Retries          int
+	MinWait, MaxWait time.Duration
+}
+
+// DefaultRetryPolicy is used by clients created with `New` and `NewWithURL`.
+var DefaultRetryPolicy = RetryPolicy{
+	Retries: 3,
+	MinWait: 5 * time.Second,
+	MaxWait: 2 * time.Minute,
+}
+
+// backoff returns how long to wait before the given retry attempt (starting
+// at 1), using exponential backoff with jitter.
+//
+// The delay is drawn uniformly from [d/2, d), where d is `MinWait` doubled
+// for every previous retry and capped to `MaxWait`. Jitter keeps a set of
+// concurrent product exports from retrying in lockstep.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.MinWait
+
+	for i := 1; i < attempt && (p.MaxWait <= 0 || d < p.MaxWait); i++ {
+		d *= 2
+	}
+
+	if p.MaxWait > 0 && d > p.MaxWait {
+		d = p.MaxWait
+	}
+
+	if d <= 1 {
+		return d
+	}
+
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)))
+}
+
+// retryableStatus reports whether a response with the given status code is
+// worth retrying: rate limiting and the server side errors that are usually
+// transient.
+func retryableStatus(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+
+	return false
+}
+
+// retryAfter parses the `Retry-After` header of the response, which may be
+// either a number of seconds or an HTTP date. Returns zero if the header is
+// missing or malformed.
+func retryAfter(resp *http.Response) time.Duration {
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+
+	if secs, err := strconv.Atoi(value); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		if d := date.Sub(time.Now()); d > 0 {
+			return d
+		}
+	}
+
+	return 0
+}
+
+// get performs a GET request against `endpoint` with the given arguments,
+// authenticated with the client's Authenticator, retrying connection failures and transient HTTP errors
+// according to the client's RetryPolicy. Cancelling `ctx` aborts both the
+// request and any pending wait between attempts.
+//
+// If `m.ReplayDir` is set, the response recorded there is returned instead of
+// making a request at all, see `m.RecordDir`.
+//
+// Only establishing the request is retried. Once a 200 OK response comes
+// back, it's returned to the caller as is, because retrying halfway through
+// streaming the body would produce duplicate records. Truncated bodies are
+// dealt with by EventReader instead. Any other status ends up as an
+// *APIError, and a request that couldn't be made at all as a *NetworkError.
+func (m *Mixpanel) get(ctx context.Context, endpoint string, args url.Values) (*http.Response, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s?%s", endpoint, args.Encode()), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	m.authenticator().Authenticate(req)
+
+	if m.ReplayDir != "" {
+		resp, err := m.replayResponse(req)
+		if err == nil && resp.StatusCode != http.StatusOK {
+			return nil, m.statusError(resp)
+		}
+
+		return resp, err
+	}
+
+	req = req.WithContext(ctx)
+	attempts := m.Retry.Retries + 1
+
+	for attempt := 1; ; attempt++ {
+		resp, err := m.do(ctx, req)
+
+		// Don't bother retrying if we were asked to stop.
+		if ctx.Err() != nil {
+			if err == nil {
+				resp.Body.Close()
+			}
+			return nil, ctx.Err()
+		}
+
+		var (
+			wait   time.Duration
+			apiErr *APIError
+		)
+
+		if err == nil {
+			if resp.StatusCode == http.StatusOK {
+				return resp, nil
+			}
+
+			wait = retryAfter(resp)
+			apiErr = m.statusError(resp)
+
+			if !retryableStatus(resp.StatusCode) {
+				return nil, apiErr
+			}
+
+			err = fmt.Errorf("server returned %s", resp.Status)
+		}
+
+		if attempt >= attempts {
+			if apiErr != nil {
+				apiErr.attempts = attempts
+				return nil, apiErr
+			}
+
+			if _, ok := err.(*url.Error); ok {
+				return nil, &NetworkError{Product: m.Product, Err: err, attempts: attempts}
+			}
+			return nil, err
+		}
+
+		// Honor the server's Retry-After if it asks for more patience
+		// than we would have shown anyway.
+		if d := m.Retry.backoff(attempt); d > wait {
+			wait = d
+		}
+
+		m.logf("%s: attempt %d/%d failed: %s, retrying in %s",
+			m.Product, attempt, attempts, err, wait)
+
+		timer := time.NewTimer(wait)
+
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// do sends a single request once the client's Limiter allows it, holding on
+// to the Limiter's slot until the response body is closed.
+func (m *Mixpanel) do(ctx context.Context, req *http.Request) (*http.Response, error) {
+	release, err := m.Limiter.acquire(ctx, func(wait time.Duration) {
+		m.logf("%s: hourly request limit reached, waiting %s", m.Product, wait)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := m.httpClient().Do(req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+
+	resp.Body = &releaseOnClose{ReadCloser: resp.Body, release: release}
+
+	if m.RecordDir != "" {
+		if err := m.recordResponse(req, resp); err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,107 @@
+package mixpanel
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	policy := RetryPolicy{Retries: 5, MinWait: time.Second, MaxWait: 5 * time.Second}
+
+	expected := []time.Duration{
+		time.Second,
+		2 * time.Second,
+		4 * time.Second,
+		5 * time.Second,
+		5 * time.Second,
+	}
+
+	for i, max := range expected {
+		if d := policy.backoff(i + 1); d < max/2 || d >= max {
+			t.Errorf("attempt %d: expected wait in [%s, %s), got %s", i+1, max/2, max, d)
+		}
+	}
+
+	// Without a cap, the delay keeps doubling.
+	policy.MaxWait = 0
+
+	if d := policy.backoff(4); d < 4*time.Second || d >= 8*time.Second {
+		t.Errorf("uncapped attempt 4: expected wait in [4s, 8s), got %s", d)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	resp := &http.Response{Header: http.Header{}}
+
+	if d := retryAfter(resp); d != 0 {
+		t.Errorf("expected no wait without header, got %s", d)
+	}
+
+	resp.Header.Set("Retry-After", "7")
+	if d := retryAfter(resp); d != 7*time.Second {
+		t.Errorf("expected 7s, got %s", d)
+	}
+
+	resp.Header.Set("Retry-After", "garbage")
+	if d := retryAfter(resp); d != 0 {
+		t.Errorf("expected no wait for bad header, got %s", d)
+	}
+}
+
+func TestGetRetries(t *testing.T) {
+	calls := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+
+		switch calls {
+		case 1:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case 2:
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			fmt.Fprintln(w, `{"event": "a", "properties": {}}`)
+		}
+	}))
+	defer server.Close()
+
+	mix := NewWithURL("product", "key", "secret", server.URL)
+	mix.Retry = RetryPolicy{Retries: 2, MinWait: time.Millisecond, MaxWait: time.Millisecond}
+
+	resp, err := mix.get(context.Background(), server.URL, url.Values{})
+	if err != nil {
+		t.Fatalf("expected success after retries, got %v", err)
+	}
+	resp.Body.Close()
+
+	if calls != 3 {
+		t.Errorf("expected 3 requests, saw %d", calls)
+	}
+}
+
+func TestGetGivesUp(t *testing.T) {
+	calls := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	mix := NewWithURL("product", "key", "secret", server.URL)
+	mix.Retry = RetryPolicy{Retries: 1, MinWait: time.Millisecond, MaxWait: time.Millisecond}
+
+	if _, err := mix.get(context.Background(), server.URL, url.Values{}); err == nil {
+		t.Error("expected error after exhausting retries")
+	}
+
+	if calls != 2 {
+		t.Errorf("expected 2 requests, saw %d", calls)
+	}
+}
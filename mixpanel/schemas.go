@@ -0,0 +1,93 @@
+package mixpanel
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+)
+
+// The official base URL of the app API, which among other things serves the
+// Lexicon schemas.
+const MixpanelAppURL = "https://mixpanel.com/api/app"
+
+// RegionAppURLs maps each of Mixpanel's data residency regions to its app
+// API base URL, see RegionBaseURLs.
+var RegionAppURLs = map[string]string{
+	"us": MixpanelAppURL,
+	"eu": "https://eu.mixpanel.com/api/app",
+	"in": "https://in.mixpanel.com/api/app",
+}
+
+// EventSchema is the definition of an event in the project's Lexicon.
+type EventSchema struct {
+	Name string
+
+	// Names of the event's properties, sorted.
+	Properties []string
+}
+
+// EventSchemas returns the definitions of all events in the Lexicon of the
+// project with the given ID, sorted by name. The schemas API only accepts
+// service account credentials, see ServiceAccountAuth.
+func (m *Mixpanel) EventSchemas(ctx context.Context, projectID string) ([]EventSchema, error) {
+	endpoint := fmt.Sprintf("%s/projects/%s/schemas/event", m.AppURL, projectID)
+
+	resp, err := m.get(ctx, endpoint, url.Values{})
+	if err != nil {
+		return nil, m.failed("fetching schemas", err)
+	}
+
+	defer resp.Body.Close()
+
+	var body struct {
+		Error   *string `json:"error"`
+		Results []struct {
+			EntityType string `json:"entityType"`
+			Name       string `json:"name"`
+			SchemaJSON struct {
+				Properties map[string]json.RawMessage `json:"properties"`
+			} `json:"schemaJson"`
+		} `json:"results"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	} else if err != nil {
+		return nil, &ParseError{Product: m.Product, Err: err}
+	}
+
+	accept(resp.Body)
+
+	if body.Error != nil {
+		return nil, &APIError{Product: m.Product, StatusCode: resp.StatusCode, Body: *body.Error}
+	}
+
+	var schemas []EventSchema
+
+	for _, result := range body.Results {
+		if result.EntityType != "" && result.EntityType != "event" {
+			continue
+		}
+
+		schema := EventSchema{Name: result.Name}
+		for name := range result.SchemaJSON.Properties {
+			schema.Properties = append(schema.Properties, name)
+		}
+
+		sort.Strings(schema.Properties)
+		schemas = append(schemas, schema)
+	}
+
+	sort.Sort(byName(schemas))
+
+	return schemas, nil
+}
+
+// byName implements sort.Interface.
+type byName []EventSchema
+
+func (s byName) Len() int           { return len(s) }
+func (s byName) Less(i, j int) bool { return s[i].Name < s[j].Name }
+func (s byName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
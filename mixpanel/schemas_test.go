@@ -0,0 +1,41 @@
+package mixpanel
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestEventSchemas(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/projects/123/schemas/event" {
+			t.Errorf("bad path: %s", r.URL.Path)
+		}
+
+		fmt.Fprint(w, `{"status": "ok", "results": [
+			{"entityType": "event", "name": "Signup", "schemaJson": {"properties": {"plan": {"type": "string"}, "$os": {}}}},
+			{"entityType": "event", "name": "Login", "schemaJson": {}}
+		]}`)
+	}))
+	defer server.Close()
+
+	mix := New("product", "key", "secret")
+	mix.AppURL = server.URL
+
+	schemas, err := mix.EventSchemas(context.Background(), "123")
+	if err != nil {
+		t.Fatalf("raised error: %v", err)
+	}
+
+	expected := []EventSchema{
+		{Name: "Login"},
+		{Name: "Signup", Properties: []string{"$os", "plan"}},
+	}
+
+	if !reflect.DeepEqual(schemas, expected) {
+		t.Errorf("expected %v, got %v", expected, schemas)
+	}
+}
@@ -0,0 +1,128 @@
+package mixpanel
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Key into the EventData map that contains the timestamp of this event in
+// the project's timezone, see `Mixpanel.LocalTimestamps`.
+const LocalTimestampKey = "$__$$local_timestamp"
+
+// TimestampFormat selects how the values under TimestampKey and
+// LocalTimestampKey are rendered.
+type TimestampFormat int
+
+const (
+	// SQLTimestamps renders timestamps as SQL Timestamp/DateTime
+	// compatible strings in whole seconds: "2006-01-02 15:04:05".
+	SQLTimestamps TimestampFormat = iota
+
+	// RFC3339Timestamps renders timestamps as RFC 3339 strings, including
+	// the UTC offset: "2006-01-02T15:04:05-07:00".
+	RFC3339Timestamps
+
+	// EpochMillisTimestamps renders timestamps as the number of
+	// milliseconds since the Unix epoch. Since these don't carry a
+	// timezone, local timestamps are identical to UTC ones.
+	EpochMillisTimestamps
+
+	// SQLMillisTimestamps is like SQLTimestamps, with milliseconds
+	// appended when known: "2006-01-02 15:04:05.999".
+	SQLMillisTimestamps
+)
+
+// ParseTimestampFormat converts the name of a TimestampFormat ("sql",
+// "sqlms", "rfc3339" or "epochms") into its value.
+func ParseTimestampFormat(name string) (TimestampFormat, error) {
+	switch name {
+	case "sql":
+		return SQLTimestamps, nil
+	case "sqlms":
+		return SQLMillisTimestamps, nil
+	case "rfc3339":
+		return RFC3339Timestamps, nil
+	case "epochms":
+		return EpochMillisTimestamps, nil
+	}
+
+	return SQLTimestamps, fmt.Errorf("unknown timestamp format: %s", name)
+}
+
+// format renders the time according to the TimestampFormat.
+func (f TimestampFormat) format(t time.Time) interface{} {
+	switch f {
+	case RFC3339Timestamps:
+		return t.Format("2006-01-02T15:04:05.999Z07:00")
+	case EpochMillisTimestamps:
+		return t.UnixNano() / int64(time.Millisecond)
+	case SQLMillisTimestamps:
+		return t.Format("2006-01-02 15:04:05.999")
+	}
+
+	return t.Format("2006-01-02 15:04:05")
+}
+
+// location returns the project's timezone, defaulting to UTC.
+func (m *Mixpanel) location() *time.Location {
+	if m.Location != nil {
+		return m.Location
+	}
+
+	return time.UTC
+}
+
+// eventTime extracts the time of an event from its properties.
+//
+// `time` is usually in whole seconds, but may be fractional. When it isn't,
+// the milliseconds are recovered from `$mp_api_timestamp_ms` (the time the
+// event reached Mixpanel) if that falls into the same second.
+//
+// Returns false if the event has no `time` property.
+func eventTime(properties map[string]interface{}) (time.Time, bool, error) {
+	prop, ok := properties["time"].(json.Number)
+	if !ok {
+		return time.Time{}, false, nil
+	}
+
+	if secs, err := prop.Int64(); err == nil {
+		t := time.Unix(secs, 0)
+
+		if ms, ok := properties["$mp_api_timestamp_ms"].(json.Number); ok {
+			if ms, err := ms.Int64(); err == nil && ms/1000 == secs {
+				t = time.Unix(0, ms*int64(time.Millisecond))
+			}
+		}
+
+		return t, true, nil
+	}
+
+	secs, err := prop.Float64()
+	if err != nil {
+		return time.Time{}, false, err
+	}
+
+	whole, frac := math.Modf(secs)
+	ms := int64(math.Floor(frac*1000 + 0.5))
+
+	return time.Unix(int64(whole), ms*int64(time.Millisecond)), true, nil
+}
+
+// timestamps renders the timestamp of the event in UTC and, if requested,
+// in the project's timezone. Either is nil if not available.
+func (m *Mixpanel) timestamps(properties map[string]interface{}) (utc, local interface{}, err error) {
+	t, ok, err := eventTime(properties)
+	if err != nil || !ok {
+		return nil, nil, err
+	}
+
+	utc = m.TimestampFormat.format(t.UTC())
+
+	if m.LocalTimestamps {
+		local = m.TimestampFormat.format(t.In(m.location()))
+	}
+
+	return utc, local, nil
+}
@@ -0,0 +1,77 @@
+package mixpanel
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestTimestampFormats(t *testing.T) {
+	ts := time.Unix(1095379200, 250*int64(time.Millisecond)).UTC()
+
+	expected := []struct {
+		Format TimestampFormat
+		Value  interface{}
+	}{
+		{SQLTimestamps, "2004-09-17 00:00:00"},
+		{SQLMillisTimestamps, "2004-09-17 00:00:00.25"},
+		{RFC3339Timestamps, "2004-09-17T00:00:00.25Z"},
+		{EpochMillisTimestamps, int64(1095379200250)},
+	}
+
+	for _, e := range expected {
+		if v := e.Format.format(ts); v != e.Value {
+			t.Errorf("format %d: expected %v, got %v", e.Format, e.Value, v)
+		}
+	}
+}
+
+func TestEventTimeMillis(t *testing.T) {
+	cases := []struct {
+		Properties map[string]interface{}
+		Millis     int64
+	}{
+		{map[string]interface{}{"time": json.Number("1000")}, 1000000},
+		{map[string]interface{}{"time": json.Number("1000.123")}, 1000123},
+		{map[string]interface{}{
+			"time":                 json.Number("1000"),
+			"$mp_api_timestamp_ms": json.Number("1000456"),
+		}, 1000456},
+		// Received much later than it happened, can't tell the ms.
+		{map[string]interface{}{
+			"time":                 json.Number("1000"),
+			"$mp_api_timestamp_ms": json.Number("5000456"),
+		}, 1000000},
+	}
+
+	for _, c := range cases {
+		ts, ok, err := eventTime(c.Properties)
+		if err != nil || !ok {
+			t.Errorf("%v: unexpected failure: %v", c.Properties, err)
+		} else if ms := ts.UnixNano() / int64(time.Millisecond); ms != c.Millis {
+			t.Errorf("%v: expected %d ms, got %d", c.Properties, c.Millis, ms)
+		}
+	}
+}
+
+func TestLocalTimestamps(t *testing.T) {
+	loc, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		t.Skip("timezone database not available")
+	}
+
+	mix := New("product", "", "")
+	mix.Location = loc
+	mix.LocalTimestamps = true
+	mix.TimestampFormat = RFC3339Timestamps
+
+	event := transformOne(t, mix, `{"event": "a", "properties": {"time": 1095379200}}`)
+
+	if v := event[TimestampKey]; v != "2004-09-17T00:00:00Z" {
+		t.Errorf("bad UTC timestamp: %v", v)
+	}
+
+	if v := event[LocalTimestampKey]; v != "2004-09-16T17:00:00-07:00" {
+		t.Errorf("bad local timestamp: %v", v)
+	}
+}
@@ -0,0 +1,135 @@
+package mixpanel
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Key into a nested or raw record holding the metadata added by the client:
+// "product", "event_id", and "timestamp" (plus "local_timestamp" if
+// requested) when the event has a time.
+const MetadataKey = "_mixport"
+
+// Key into a raw record holding the record exactly as it was received, as a
+// json.RawMessage. Exporters writing JSON should write these bytes instead of
+// encoding the record.
+const RawKey = "$__$$raw"
+
+// TransformMode selects the shape of the records produced by the client.
+type TransformMode int
+
+const (
+	// FlattenRecords merges the event's properties into the top level of
+	// the record, along with "product", "event", EventIDKey and
+	// TimestampKey. Properties with any of those names are dealt with
+	// according to the client's CollisionPolicy.
+	FlattenRecords TransformMode = iota
+
+	// NestedRecords keeps Mixpanel's `{"event": .., "properties": {..}}`
+	// shape, with the client's metadata in a separate object under
+	// MetadataKey.
+	NestedRecords
+
+	// RawRecords is like NestedRecords, but additionally keeps the
+	// original bytes of the record under RawKey.
+	RawRecords
+)
+
+// ParseTransformMode converts the name of a TransformMode ("flatten",
+// "nested" or "raw") into its value.
+func ParseTransformMode(name string) (TransformMode, error) {
+	switch name {
+	case "flatten":
+		return FlattenRecords, nil
+	case "nested":
+		return NestedRecords, nil
+	case "raw":
+		return RawRecords, nil
+	}
+
+	return FlattenRecords, fmt.Errorf("unknown transform mode: %s", name)
+}
+
+// record assembles the transformed record according to the client's
+// TransformMode. `utc` and `local` are the rendered timestamps, nil if
+// missing or not requested.
+//
+// Flattened records can fail due to the client's CollisionPolicy.
+func (m *Mixpanel) record(ev *rawEvent, id string, utc, local interface{}) (EventData, error) {
+	if m.Transform == FlattenRecords {
+		record := EventData(ev.Properties)
+
+		reserved := []struct {
+			key   string
+			value interface{}
+		}{
+			{EventIDKey, id},
+			{TimestampKey, utc},
+			{LocalTimestampKey, local},
+			{"product", m.Product},
+			{"event", ev.Event},
+		}
+
+		for _, r := range reserved {
+			if r.value == nil {
+				continue
+			}
+
+			if err := m.set(record, ev.Event, r.key, r.value); err != nil {
+				return nil, err
+			}
+		}
+
+		return record, nil
+	}
+
+	meta := map[string]interface{}{
+		"product":  m.Product,
+		"event_id": id,
+	}
+
+	if utc != nil {
+		meta["timestamp"] = utc
+	}
+
+	if local != nil {
+		meta["local_timestamp"] = local
+	}
+
+	record := EventData{
+		"event":      ev.Event,
+		"properties": ev.Properties,
+		MetadataKey:  meta,
+	}
+
+	if m.Transform == RawRecords {
+		record[RawKey] = ev.raw
+	}
+
+	return record, nil
+}
+
+// Lookup finds the value of `key` in a record of any TransformMode. The top
+// level of the record is searched first, then the event's properties, then
+// the client's metadata.
+func (e EventData) Lookup(key string) (interface{}, bool) {
+	if value, ok := e[key]; ok {
+		return value, true
+	}
+
+	for _, nested := range []string{"properties", MetadataKey} {
+		if values, ok := e[nested].(map[string]interface{}); ok {
+			if value, ok := values[key]; ok {
+				return value, true
+			}
+		}
+	}
+
+	return nil, false
+}
+
+// Raw returns the original bytes of a raw record, see RawRecords.
+func (e EventData) Raw() (json.RawMessage, bool) {
+	raw, ok := e[RawKey].(json.RawMessage)
+	return raw, ok
+}
@@ -0,0 +1,86 @@
+package mixpanel
+
+import (
+	"testing"
+)
+
+func TestNestedRecords(t *testing.T) {
+	mix := New("product", "", "")
+	mix.Transform = NestedRecords
+
+	event := transformOne(t, mix, `{"event": "a", "properties": {"time": 1, "event": "x", "product": "y"}}`)
+
+	props, ok := event["properties"].(map[string]interface{})
+	if !ok || props["event"] != "x" || props["product"] != "y" {
+		t.Errorf("expected properties to be untouched, got %v", event["properties"])
+	}
+
+	if _, ok := props[EventIDKey]; ok {
+		t.Error("expected no metadata in properties")
+	}
+
+	meta, ok := event[MetadataKey].(map[string]interface{})
+	if !ok || meta["product"] != "product" || meta["event_id"] == nil || meta["timestamp"] == nil {
+		t.Errorf("bad metadata: %v", event[MetadataKey])
+	}
+
+	if event["event"] != "a" {
+		t.Errorf("bad event name: %v", event["event"])
+	}
+
+	if _, ok := event.Raw(); ok {
+		t.Error("expected no raw bytes")
+	}
+}
+
+func TestRawRecords(t *testing.T) {
+	mix := New("product", "", "")
+	mix.Transform = RawRecords
+
+	line := `{"properties":{"time":1,"url":"a<b>&c","f":1.50},"event":"a"}`
+	event := transformOne(t, mix, line)
+
+	if raw, ok := event.Raw(); !ok || string(raw) != line {
+		t.Errorf("expected raw record (%s), got (%s)", line, raw)
+	}
+
+	if v, _ := event.Lookup("url"); v != "a<b>&c" {
+		t.Errorf("expected properties to be decoded, got %v", v)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	record := EventData{
+		"event":      "a",
+		"properties": map[string]interface{}{"k": "v", "event": "x"},
+		MetadataKey:  map[string]interface{}{"product": "p"},
+	}
+
+	expected := map[string]interface{}{"event": "a", "k": "v", "product": "p"}
+
+	for key, value := range expected {
+		if v, ok := record.Lookup(key); !ok || v != value {
+			t.Errorf("%s: expected %v, got %v", key, value, v)
+		}
+	}
+
+	if _, ok := record.Lookup("missing"); ok {
+		t.Error("expected missing key not to be found")
+	}
+}
+
+func TestParseTransformMode(t *testing.T) {
+	for name, mode := range map[string]TransformMode{
+		"flatten": FlattenRecords,
+		"nested":  NestedRecords,
+		"raw":     RawRecords,
+	} {
+		if m, err := ParseTransformMode(name); err != nil || m != mode {
+			t.Errorf("%s: expected %d, got %d (%v)", name, mode, m, err)
+		}
+	}
+
+	if _, err := ParseTransformMode("bogus"); err == nil {
+		t.Error("expected error for unknown mode")
+	}
+}